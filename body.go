@@ -0,0 +1,83 @@
+package regrouter
+
+import (
+	"io"
+	"net/http"
+)
+
+// limitedBody is a request body limited in size, recording whether a read
+// went past the limit
+type limitedBody struct {
+	io.ReadCloser
+	limit    int64
+	read     int64
+	exceeded bool
+}
+
+// AddMaxBody adds a route whose request body is limited to limit bytes, a
+// larger body getting a 413 error. It overrides the MaxBody limit.
+func (rr *RegRouter) AddMaxBody(method string, pattern string, handler http.HandlerFunc, cors bool, limit int64) {
+	if err := rr.add(Route{method: method, handler: handler, maxBody: limit, CORS: cors}, pattern); err != nil {
+		panic(err)
+	}
+}
+
+// MaxBody limits the request body of every route to limit bytes, a larger body
+// getting a 413 error. A limit of 0 disables it.
+func (rr *RegRouter) MaxBody(limit int64) {
+	rr.maxBody = limit
+}
+
+// limitBody runs the handler with the request body limited, responding with a
+// 413 error if it is too large and the handler hasn't responded already
+func (rr *RegRouter) limitBody(limit int64, handler http.Handler, w http.ResponseWriter, r *http.Request) {
+	if r.ContentLength > limit {
+		rr.Error(http.StatusRequestEntityTooLarge, map[string]interface{}{}, w, r)
+		return
+	} else if r.Body == nil {
+		handler.ServeHTTP(w, r)
+		return
+	}
+
+	rw, w := wrapWriter(w)
+	body := &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, limit), limit: limit}
+	r.Body = body
+	handler.ServeHTTP(w, r)
+
+	if body.exceeded && !rw.written() {
+		rr.Error(http.StatusRequestEntityTooLarge, map[string]interface{}{}, w, r)
+	}
+}
+
+// Read reads the body, recording a read past the limit
+func (lb *limitedBody) Read(p []byte) (int, error) {
+	n, err := lb.ReadCloser.Read(p)
+	lb.read += int64(n)
+	if err != nil && err != io.EOF && lb.read >= lb.limit {
+		lb.exceeded = true
+	}
+
+	return n, err
+}
+
+// MaxHeaderBytes limits the total size of the request headers to limit bytes,
+// larger headers getting a 431 error. A limit of 0 disables it.
+func (rr *RegRouter) MaxHeaderBytes(limit int) {
+	rr.maxHeaderBytes = limit
+}
+
+// headerSize returns the size of the request header lines, Host included
+func headerSize(r *http.Request) int {
+	size := 0
+	if r.Host != "" {
+		size += len("Host: \r\n") + len(r.Host)
+	}
+
+	for key, values := range r.Header {
+		for _, value := range values {
+			size += len(key) + len(": \r\n") + len(value)
+		}
+	}
+
+	return size
+}
@@ -0,0 +1,84 @@
+package regrouter
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// servePost runs a POST request with the body through the handler, without a
+// content length if chunked is set
+func servePost(h http.Handler, path string, body string, chunked bool) *httptest.ResponseRecorder {
+	var reader io.Reader = strings.NewReader(body)
+	if chunked {
+		// Hide the length from NewRequest
+		reader = struct{ io.Reader }{reader}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, path, reader)
+	if chunked {
+		r.ContentLength = -1
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestAddMaxBody(t *testing.T) {
+	rr := New()
+	got := ""
+	rr.AddMaxBody(http.MethodPost, "/up", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		got = string(body)
+		if err == nil {
+			io.WriteString(w, "ok")
+		}
+	}, false, 5)
+
+	tests := []struct {
+		body    string
+		chunked bool
+		code    int
+	}{
+		{"12345", false, http.StatusOK},
+		{"123456", false, http.StatusRequestEntityTooLarge},
+		{"12345", true, http.StatusOK},
+		{"123456", true, http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		if w := servePost(rr, "/up", test.body, test.chunked); w.Code != test.code {
+			t.Errorf("POST /up %q, chunked %t: got %d, want %d", test.body, test.chunked, w.Code, test.code)
+		}
+	}
+
+	// A declared length over the limit is rejected before the handler runs
+	got = "unset"
+	if servePost(rr, "/up", "123456", false); got != "unset" {
+		t.Errorf("POST /up over the limit: handler read %q", got)
+	}
+}
+
+func TestMaxBody(t *testing.T) {
+	rr := New()
+	rr.Post("/big", okHandler, false)
+	rr.AddMaxBody(http.MethodPost, "/up", okHandler, false, 5)
+	rr.MaxBody(3)
+
+	if w := servePost(rr, "/big", "1234", false); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("POST /big over the default limit: got %d, want 413", w.Code)
+	}
+	if w := servePost(rr, "/big", "123", false); w.Code != http.StatusOK {
+		t.Errorf("POST /big under the default limit: got %d, want 200", w.Code)
+	}
+
+	// The route limit overrides the default
+	if w := servePost(rr, "/up", "1234", false); w.Code != http.StatusOK {
+		t.Errorf("POST /up under its own limit: got %d, want 200", w.Code)
+	}
+	if w := serve(rr, http.MethodGet, "/x"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /x: got %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,119 @@
+package regrouter
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// mediaRange is a media type of an Accept header with its quality
+type mediaRange struct {
+	mediaType string
+	q         float64
+}
+
+// AddConsumes adds a route only matching requests with one of the content
+// types, such as application/json or text/*
+func (rr *RegRouter) AddConsumes(method string, pattern string, handler http.HandlerFunc, cors bool, contentTypes ...string) {
+	if err := rr.add(Route{method: method, handler: handler, consumes: contentTypes, CORS: cors}, pattern); err != nil {
+		panic(err)
+	}
+}
+
+// AddProduces adds a route only matching requests accepting one of the media
+// types, or without an Accept header
+func (rr *RegRouter) AddProduces(method string, pattern string, handler http.HandlerFunc, cors bool, mediaTypes ...string) {
+	if err := rr.add(Route{method: method, handler: handler, produces: mediaTypes, CORS: cors}, pattern); err != nil {
+		panic(err)
+	}
+}
+
+// matchContentType reports whether the route consumes the request content type
+func (route Route) matchContentType(r *http.Request) bool {
+	if route.consumes == nil {
+		return true
+	}
+
+	contentType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+
+	for _, consumed := range route.consumes {
+		if mediaMatch(consumed, contentType) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchAccept reports whether the request accepts a media type the route
+// produces
+func (route Route) matchAccept(r *http.Request) bool {
+	if route.produces == nil || r.Header.Get("Accept") == "" {
+		return true
+	}
+
+	ranges := acceptRanges(r)
+	for _, produced := range route.produces {
+		if acceptQuality(ranges, strings.ToLower(produced)) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// acceptQuality returns the quality of the most specific media range matching
+// the media type, or 0 if none does
+func acceptQuality(ranges []mediaRange, mediaType string) float64 {
+	q, specificity := 0.0, -1
+	for _, accepted := range ranges {
+		if !mediaMatch(accepted.mediaType, mediaType) && !mediaMatch(mediaType, accepted.mediaType) {
+			continue
+		}
+
+		// Prefer exact types over type/* over */*
+		n := 2 - strings.Count(accepted.mediaType, "*")
+		if n > specificity {
+			q, specificity = accepted.q, n
+		}
+	}
+
+	return q
+}
+
+// acceptRanges returns the valid media ranges of the request Accept header
+func acceptRanges(r *http.Request) []mediaRange {
+	var ranges []mediaRange
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(accept)
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+
+		ranges = append(ranges, mediaRange{mediaType, q})
+	}
+
+	return ranges
+}
+
+// mediaMatch reports whether the media type is in the media range, which may
+// be */* or have a wildcard subtype
+func mediaMatch(mediaRange string, mediaType string) bool {
+	mediaRange = strings.ToLower(mediaRange)
+	if mediaRange == "*/*" || mediaRange == mediaType {
+		return true
+	}
+
+	return strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*"))
+}
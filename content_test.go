@@ -0,0 +1,84 @@
+package regrouter
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// serveHeader runs a request with the header set through the handler
+func serveHeader(h http.Handler, method string, path string, key string, value string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	if value != "" {
+		r.Header.Set(key, value)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// write returns a handler writing the body
+func write(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}
+}
+
+func TestAddConsumes(t *testing.T) {
+	rr := New()
+	rr.AddConsumes(http.MethodPost, "/a", write("json"), false, "application/json")
+	rr.AddConsumes(http.MethodPost, "/a", write("text"), false, "text/*")
+
+	tests := []struct {
+		contentType string
+		code        int
+		body        string
+	}{
+		{"application/json; charset=utf-8", http.StatusOK, "json"},
+		{"text/plain", http.StatusOK, "text"},
+		{"image/png", http.StatusNotFound, ""},
+		{"", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		w := serveHeader(rr, http.MethodPost, "/a", "Content-Type", test.contentType)
+		if w.Code != test.code || test.body != "" && w.Body.String() != test.body {
+			t.Errorf("POST /a with %q: got %d %q, want %d %q", test.contentType, w.Code, w.Body, test.code, test.body)
+		}
+	}
+}
+
+func TestAddProduces(t *testing.T) {
+	rr := New()
+	rr.AddProduces(http.MethodGet, "/b", write("html"), false, "text/html")
+	rr.Get("/b", write("other"), false)
+
+	tests := map[string]string{"": "html", "text/*": "html", "application/json": "other", "text/html;q=0, */*": "other"}
+	for accept, want := range tests {
+		if w := serveHeader(rr, http.MethodGet, "/b", "Accept", accept); w.Body.String() != want {
+			t.Errorf("GET /b accepting %q: got %q, want %q", accept, w.Body, want)
+		}
+	}
+}
+
+func TestNotAcceptable(t *testing.T) {
+	rr := New()
+	rr.AddProduces(http.MethodGet, "/b", okHandler, false, "text/html")
+	rr.Post("/b", okHandler, false)
+
+	tests := []struct {
+		method, accept string
+		code           int
+	}{
+		{http.MethodGet, "application/json", http.StatusNotAcceptable},
+		{http.MethodHead, "application/json", http.StatusNotAcceptable},
+		{http.MethodGet, "text/html", http.StatusOK},
+		{http.MethodPut, "application/json", http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		if w := serveHeader(rr, test.method, "/b", "Accept", test.accept); w.Code != test.code {
+			t.Errorf("%s /b accepting %q: got %d, want %d", test.method, test.accept, w.Code, test.code)
+		}
+	}
+}
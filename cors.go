@@ -0,0 +1,118 @@
+package regrouter
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CORSConfig is the CORS policy of a route
+type CORSConfig struct {
+	AllowOrigins     []string // Allowed origins, "*" allowing any origin.
+	AllowHeaders     []string
+	ExposeHeaders    []string
+	AllowCredentials bool
+	MaxAge           int // Seconds a preflight response may be cached.
+}
+
+// CORSDefaults are the settings of the default CORS handler
+type CORSDefaults struct {
+	AllowOrigins     []string // Origins reflected, "*" reflecting any origin.
+	AllowHeaders     []string
+	AllowCredentials bool
+	MaxAge           int // Seconds a preflight response may be cached, if set.
+}
+
+// SetCORSDefaults configures the default CORS handler
+func (rr *RegRouter) SetCORSDefaults(defaults CORSDefaults) {
+	rr.corsDefaults = defaults
+}
+
+// defaultCORS is the default CORS handler, sending the CORS headers if the
+// request origin is allowed
+func (rr *RegRouter) defaultCORS(methods []string, w http.ResponseWriter, r *http.Request) {
+	headers := w.Header()
+	headers.Add("Vary", "Origin")
+
+	origin := r.Header.Get("Origin")
+	if !originAllowed(rr.corsDefaults.AllowOrigins, origin) {
+		return
+	}
+
+	headers.Set("Access-Control-Allow-Origin", origin)
+	headers.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if rr.corsDefaults.AllowCredentials {
+		headers.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if len(rr.corsDefaults.AllowHeaders) > 0 {
+		headers.Set("Access-Control-Allow-Headers", strings.Join(rr.corsDefaults.AllowHeaders, ", "))
+	}
+
+	if rr.corsDefaults.MaxAge > 0 {
+		headers.Set("Access-Control-Max-Age", strconv.Itoa(rr.corsDefaults.MaxAge))
+	}
+}
+
+// AddCORS adds a route answering CORS requests with its own policy instead of
+// the CORS handler
+func (rr *RegRouter) AddCORS(method string, pattern string, handler http.HandlerFunc, config CORSConfig) {
+	if err := rr.add(Route{method: method, handler: handler, corsConfig: &config, CORS: true}, pattern); err != nil {
+		panic(err)
+	}
+}
+
+// cors sends the CORS headers of the config, or from the CORS handler if
+// there is no config
+func (rr *RegRouter) cors(config *CORSConfig, methods []string, w http.ResponseWriter, r *http.Request) {
+	if config != nil {
+		config.headers(methods, w, r)
+	} else if rr.Handlers.CORS != nil {
+		rr.Handlers.CORS(methods, w, r)
+	}
+}
+
+// headers sends the CORS headers if the request origin is allowed
+func (config *CORSConfig) headers(methods []string, w http.ResponseWriter, r *http.Request) {
+	headers := w.Header()
+	headers.Add("Vary", "Origin")
+
+	origin := r.Header.Get("Origin")
+	if !config.allowed(origin) {
+		return
+	}
+
+	if contains(config.AllowOrigins, "*") && !config.AllowCredentials {
+		headers.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		headers.Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if config.AllowCredentials {
+		headers.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	// Preflight requests get the allowed methods and headers, others the exposed headers
+	if r.Method == http.MethodOptions {
+		headers.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		if len(config.AllowHeaders) > 0 {
+			headers.Set("Access-Control-Allow-Headers", strings.Join(config.AllowHeaders, ", "))
+		}
+
+		if config.MaxAge > 0 {
+			headers.Set("Access-Control-Max-Age", strconv.Itoa(config.MaxAge))
+		}
+	} else if len(config.ExposeHeaders) > 0 {
+		headers.Set("Access-Control-Expose-Headers", strings.Join(config.ExposeHeaders, ", "))
+	}
+}
+
+// allowed reports whether the origin is allowed by the config
+func (config *CORSConfig) allowed(origin string) bool {
+	return originAllowed(config.AllowOrigins, origin)
+}
+
+// originAllowed reports whether the origin is in the allowed origins
+func originAllowed(origins []string, origin string) bool {
+	return origin != "" && (contains(origins, "*") || contains(origins, origin))
+}
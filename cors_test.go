@@ -0,0 +1,179 @@
+package regrouter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// serveOrigin runs a request from the origin through the handler, an OPTIONS
+// preflight request for the method if preflight is set
+func serveOrigin(h http.Handler, method string, path string, origin string, preflight bool) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	if preflight {
+		r = httptest.NewRequest(http.MethodOptions, path, nil)
+		r.Header.Set("Access-Control-Request-Method", method)
+	}
+
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestAddCORS(t *testing.T) {
+	rr := New()
+	rr.AddCORS(http.MethodPost, "/c", okHandler, CORSConfig{
+		AllowOrigins:     []string{"https://a.example"},
+		AllowHeaders:     []string{"X-Y"},
+		ExposeHeaders:    []string{"X-E"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	})
+
+	w := serveOrigin(rr, http.MethodPost, "/c", "https://a.example", true)
+	headers := w.Header()
+	if w.Code != http.StatusNoContent || headers.Get("Access-Control-Allow-Origin") != "https://a.example" || headers.Get("Access-Control-Allow-Methods") != "POST" || headers.Get("Access-Control-Allow-Headers") != "X-Y" {
+		t.Errorf("preflight from an allowed origin: got %d %v", w.Code, headers)
+	}
+	if headers.Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("preflight max-age: got %q, want 600", headers.Get("Access-Control-Max-Age"))
+	}
+
+	w = serveOrigin(rr, http.MethodPost, "/c", "https://a.example", false)
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Expose-Headers") != "X-E" || w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("POST from an allowed origin: got %d %v", w.Code, w.Header())
+	}
+
+	// A disallowed origin gets no CORS headers, but the route still runs
+	w = serveOrigin(rr, http.MethodPost, "/c", "https://evil.example", false)
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("POST from a disallowed origin: got %d %v", w.Code, w.Header())
+	}
+	w = serveOrigin(rr, http.MethodPost, "/c", "https://evil.example", true)
+	if w.Header().Get("Access-Control-Allow-Origin") != "" || w.Header().Get("Access-Control-Max-Age") != "" {
+		t.Errorf("preflight from a disallowed origin: got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestCORSMethodsPerPath(t *testing.T) {
+	rr := New()
+	rr.SetCORSDefaults(CORSDefaults{AllowOrigins: []string{"*"}})
+	rr.Get(`/items/(?P<id>\d+)`, okHandler, true)
+	rr.Post("/items/.*", okHandler, true)
+	rr.Put(`/items/(?P<id>\d+)`, okHandler, true)
+	rr.Delete("/other", okHandler, true)
+	rr.Get("/items/new", okHandler, true)
+	rr.Patch(`/items/(?P<id>\d+)`, okHandler, false)
+
+	// Only the CORS routes matching the path are advertised
+	tests := []struct {
+		method, path string
+		preflight    bool
+		want         string
+	}{
+		{http.MethodGet, "/items/1", false, "GET, POST, PUT"},
+		{http.MethodPost, "/items/new", true, "POST, GET"},
+		{http.MethodDelete, "/other", true, "DELETE"},
+	}
+	for _, test := range tests {
+		w := serveOrigin(rr, test.method, test.path, "https://a.example", test.preflight)
+		if got := w.Header().Get("Access-Control-Allow-Methods"); got != test.want {
+			t.Errorf("%s %s: got methods %q, want %q", test.method, test.path, got, test.want)
+		}
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	rr := New()
+	rr.SetCORSDefaults(CORSDefaults{AllowOrigins: []string{"*"}})
+	ran := false
+	rr.Post("/c", okHandler, true)
+	rr.Options("/c", func(w http.ResponseWriter, r *http.Request) { ran = true }, true)
+	rr.Post("/n", okHandler, false)
+
+	// Preflight requests are answered without running the OPTIONS route
+	w := serveOrigin(rr, http.MethodPost, "/c", "https://a.example", true)
+	if w.Code != http.StatusNoContent || ran || w.Header().Get("Access-Control-Allow-Methods") != "POST, OPTIONS" || w.Header().Get("Access-Control-Allow-Origin") != "https://a.example" {
+		t.Errorf("preflight to /c: got %d, ran %t, %v", w.Code, ran, w.Header())
+	}
+	if serve(rr, http.MethodOptions, "/c"); !ran {
+		t.Error("OPTIONS /c without preflight: route didn't run")
+	}
+
+	w = serveOrigin(rr, http.MethodPost, "/n", "https://a.example", true)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("preflight to the non-CORS /n: got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestCORSDefaults(t *testing.T) {
+	rr := New()
+	rr.Post("/a", okHandler, true)
+	defaults := rr.corsDefaults
+	defaults.AllowOrigins = []string{"*"}
+	rr.SetCORSDefaults(defaults)
+
+	headers := serveOrigin(rr, http.MethodPost, "/a", "https://a.example", true).Header()
+	if headers.Get("Access-Control-Allow-Headers") != "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization" || headers.Get("Access-Control-Allow-Credentials") != "true" || headers.Get("Access-Control-Max-Age") != "" {
+		t.Errorf("preflight with the default settings: got %v", headers)
+	}
+
+	rr.SetCORSDefaults(CORSDefaults{AllowOrigins: []string{"*"}, AllowHeaders: []string{"X-A", "X-B"}, MaxAge: 60})
+	headers = serveOrigin(rr, http.MethodPost, "/a", "https://a.example", true).Header()
+	if headers.Get("Access-Control-Allow-Headers") != "X-A, X-B" || headers["Access-Control-Allow-Credentials"] != nil || headers.Get("Access-Control-Max-Age") != "60" {
+		t.Errorf("preflight with configured settings: got %v", headers)
+	}
+}
+
+func TestCORSVary(t *testing.T) {
+	rr := New()
+	rr.Post("/a", okHandler, true)
+	rr.Get("/b", okHandler, true)
+
+	if w := serveOrigin(rr, http.MethodPost, "/a", "https://a.example", true); !reflect.DeepEqual(w.Header()["Vary"], []string{"Origin"}) {
+		t.Errorf("preflight Vary: got %q, want [Origin]", w.Header()["Vary"])
+	}
+
+	// An existing Vary value is kept
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Vary", "Accept-Encoding")
+		rr.ServeHTTP(w, r)
+	})
+	if w := serveOrigin(h, http.MethodGet, "/b", "https://a.example", false); !reflect.DeepEqual(w.Header()["Vary"], []string{"Accept-Encoding", "Origin"}) {
+		t.Errorf("GET Vary: got %q, want [Accept-Encoding Origin]", w.Header()["Vary"])
+	}
+}
+
+func TestCORSAllowOrigins(t *testing.T) {
+	rr := New()
+	rr.Post("/a", okHandler, true)
+	origin := func(from string) string {
+		return serveOrigin(rr, http.MethodPost, "/a", from, true).Header().Get("Access-Control-Allow-Origin")
+	}
+
+	// No origin is reflected until allowed
+	if got := origin("https://a.example"); got != "" {
+		t.Errorf("origin by default: got %q, want none", got)
+	}
+
+	defaults := rr.corsDefaults
+	defaults.AllowOrigins = []string{"https://ok.example"}
+	rr.SetCORSDefaults(defaults)
+	tests := map[string]string{"https://ok.example": "https://ok.example", "https://evil.example": "", "": ""}
+	for from, want := range tests {
+		if got := origin(from); got != want {
+			t.Errorf("origin %q: got %q, want %q", from, got, want)
+		}
+	}
+
+	defaults.AllowOrigins = []string{"*"}
+	rr.SetCORSDefaults(defaults)
+	if got := origin("https://evil.example"); got != "https://evil.example" {
+		t.Errorf("origin with any allowed: got %q, want it reflected", got)
+	}
+}
@@ -0,0 +1,47 @@
+package regrouter
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// globParam is a valid glob param name
+var globParam = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// AddGlob adds a route with a glob pattern instead of a regex, where a :name
+// segment captures a single path segment and a final *name segment the rest
+// of the path
+func (rr *RegRouter) AddGlob(method string, glob string, handler http.HandlerFunc, cors bool) {
+	if err := rr.add(Route{method: method, handler: handler, glob: true, CORS: cors}, glob); err != nil {
+		panic(err)
+	}
+}
+
+// globExpr translates a glob pattern to a regex
+func globExpr(glob string) (string, error) {
+	segments := strings.Split(glob, "/")
+	for i, segment := range segments {
+		switch {
+		case strings.HasPrefix(segment, ":"):
+			if !globParam.MatchString(segment[1:]) {
+				return "", fmt.Errorf("invalid param %q", segment)
+			}
+
+			segments[i] = "(?P<" + segment[1:] + ">[^/]+)"
+		case strings.HasPrefix(segment, "*"):
+			if !globParam.MatchString(segment[1:]) {
+				return "", fmt.Errorf("invalid param %q", segment)
+			} else if i != len(segments)-1 {
+				return "", fmt.Errorf("%q isn't the last segment", segment)
+			}
+
+			segments[i] = "(?P<" + segment[1:] + ">.*)"
+		default:
+			segments[i] = regexp.QuoteMeta(segment)
+		}
+	}
+
+	return strings.Join(segments, "/"), nil
+}
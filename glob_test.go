@@ -0,0 +1,59 @@
+package regrouter
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAddGlob(t *testing.T) {
+	rr := New()
+	var got string
+	rr.AddGlob(http.MethodGet, "/users/:id/posts/:postID", func(w http.ResponseWriter, r *http.Request) {
+		params := rr.Params(r)
+		got = params.Get("id") + " " + params.Get("postID")
+	}, false)
+	rr.AddGlob(http.MethodGet, "/files/*path", func(w http.ResponseWriter, r *http.Request) {
+		got = rr.Params(r).Get("path")
+	}, false)
+	rr.AddGlob(http.MethodGet, "/a.b", func(w http.ResponseWriter, r *http.Request) { got = "dot" }, false)
+
+	tests := []struct {
+		path string
+		code int
+		want string
+	}{
+		{"/users/7/posts/9", http.StatusOK, "7 9"},
+		{"/users/7/posts/9/x", http.StatusNotFound, ""},
+		{"/files/a/b.txt", http.StatusOK, "a/b.txt"},
+		{"/a.b", http.StatusOK, "dot"},
+		{"/aXb", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		got = ""
+		if w := serve(rr, http.MethodGet, test.path); w.Code != test.code || got != test.want {
+			t.Errorf("GET %s: got %d %q, want %d %q", test.path, w.Code, got, test.code, test.want)
+		}
+	}
+
+	// Routes keep the glob, recompiled to match regardless of case
+	rr.CaseInsensitive(true)
+	if w := serve(rr, http.MethodGet, "/USERS/1/posts/2"); w.Code != http.StatusOK || got != "1 2" {
+		t.Errorf("GET /USERS/1/posts/2: got %d %q, want 200 \"1 2\"", w.Code, got)
+	}
+	if pattern := rr.Routes()[0].Pattern; pattern != "/users/:id/posts/:postID" {
+		t.Errorf("route pattern: got %q, want the glob", pattern)
+	}
+}
+
+func TestAddGlobInvalid(t *testing.T) {
+	for _, glob := range []string{"/:", "/*x/y", "/:a-b"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("AddGlob(%q) didn't panic", glob)
+				}
+			}()
+			New().AddGlob(http.MethodGet, glob, okHandler, false)
+		}()
+	}
+}
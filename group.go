@@ -0,0 +1,86 @@
+package regrouter
+
+import "net/http"
+
+// Group adds routes to a RegRouter under a shared pattern prefix
+type Group struct {
+	rr         *RegRouter
+	prefix     string
+	middleware []Middleware
+}
+
+// Group returns a Group prefixing every route added through it
+func (rr *RegRouter) Group(prefix string) *Group {
+	return &Group{rr: rr, prefix: prefix}
+}
+
+// Group returns a nested Group, combining the prefixes and middleware
+func (g *Group) Group(prefix string) *Group {
+	return &Group{rr: g.rr, prefix: g.prefix + prefix, middleware: g.with()}
+}
+
+// Use appends middleware to the Group. It wraps the routes added through the
+// Group afterwards, inside any RegRouter middleware.
+func (g *Group) Use(mw ...Middleware) {
+	g.middleware = append(g.middleware, mw...)
+}
+
+// Add adds a prefixed route to the RegRouter, panicking on an invalid pattern
+func (g *Group) Add(method string, pattern string, handler http.HandlerFunc, cors bool) {
+	g.AddWith(method, pattern, handler, cors)
+}
+
+// AddE adds a prefixed route to the RegRouter or returns the pattern compile error
+func (g *Group) AddE(method string, pattern string, handler http.HandlerFunc, cors bool) error {
+	return g.rr.add(Route{method: method, handler: handler, middleware: g.with(), CORS: cors}, g.prefix+pattern)
+}
+
+// AddWith adds a prefixed route wrapped in its own middleware
+func (g *Group) AddWith(method string, pattern string, handler http.HandlerFunc, cors bool, mw ...Middleware) {
+	g.rr.AddWith(method, g.prefix+pattern, handler, cors, g.with(mw...)...)
+}
+
+// with returns a copy of the Group middleware followed by mw
+func (g *Group) with(mw ...Middleware) []Middleware {
+	return append(append([]Middleware{}, g.middleware...), mw...)
+}
+
+// Get adds a prefixed GET route to the RegRouter
+func (g *Group) Get(pattern string, handler http.HandlerFunc, cors bool) {
+	g.Add(http.MethodGet, pattern, handler, cors)
+}
+
+// Post adds a prefixed POST route to the RegRouter
+func (g *Group) Post(pattern string, handler http.HandlerFunc, cors bool) {
+	g.Add(http.MethodPost, pattern, handler, cors)
+}
+
+// Put adds a prefixed PUT route to the RegRouter
+func (g *Group) Put(pattern string, handler http.HandlerFunc, cors bool) {
+	g.Add(http.MethodPut, pattern, handler, cors)
+}
+
+// Patch adds a prefixed PATCH route to the RegRouter
+func (g *Group) Patch(pattern string, handler http.HandlerFunc, cors bool) {
+	g.Add(http.MethodPatch, pattern, handler, cors)
+}
+
+// Delete adds a prefixed DELETE route to the RegRouter
+func (g *Group) Delete(pattern string, handler http.HandlerFunc, cors bool) {
+	g.Add(http.MethodDelete, pattern, handler, cors)
+}
+
+// Head adds a prefixed HEAD route to the RegRouter
+func (g *Group) Head(pattern string, handler http.HandlerFunc, cors bool) {
+	g.Add(http.MethodHead, pattern, handler, cors)
+}
+
+// Options adds a prefixed OPTIONS route to the RegRouter
+func (g *Group) Options(pattern string, handler http.HandlerFunc, cors bool) {
+	g.Add(http.MethodOptions, pattern, handler, cors)
+}
+
+// Any adds a prefixed route to the RegRouter matching every request method
+func (g *Group) Any(pattern string, handler http.HandlerFunc, cors bool) {
+	g.Add(methodAny, pattern, handler, cors)
+}
@@ -0,0 +1,37 @@
+package regrouter
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGroupNested(t *testing.T) {
+	rr := New()
+	var order []string
+	api := rr.Group("/api")
+	api.Use(trace(&order, "api"))
+	v1 := api.Group("/v1")
+	v1.Use(trace(&order, "v1"))
+	v1.Get(`/users/(?P<id>\d+)`, func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "user "+rr.Params(r).Get("id"))
+	}, false)
+	api.Get("/ping", okHandler, false)
+
+	serve(rr, http.MethodGet, "/api/v1/users/5")
+	if got := strings.Join(order, ", "); got != "api, v1, user 5" {
+		t.Errorf("GET /api/v1/users/5: got order %q", got)
+	}
+
+	// Middleware added to the nested group doesn't reach its parent
+	order = nil
+	if w := serve(rr, http.MethodGet, "/api/ping"); w.Code != http.StatusOK || strings.Join(order, ", ") != "api" {
+		t.Errorf("GET /api/ping: got %d, order %q", w.Code, order)
+	}
+
+	for _, path := range []string{"/v1/users/5", "/users/5", "/api/users/5"} {
+		if w := serve(rr, http.MethodGet, path); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s: got %d, want 404", path, w.Code)
+		}
+	}
+}
@@ -2,143 +2,1538 @@ package regrouter
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"path"
 	"regexp"
+	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// RegRouter is the RegRouter instance
+// methodAny is the route method matching every request method
+const methodAny = "*"
+
+// ErrNilHandler is returned when a route is added without a handler
+var ErrNilHandler = errors.New("nil handler")
+
+// ErrDuplicateRoute is returned when a route is added with the method and
+// pattern of an existing route of the same or a higher priority, which would
+// always match first
+var ErrDuplicateRoute = errors.New("duplicate route")
+
+// ctxKey is the context key a RegRouter stores the request Params and route
+// under by pointer, distinct from any other key, and boxed without allocating
+type ctxKey struct {
+	_ byte // Keeps each allocated key distinct.
+}
+
+// innermostKey is the context key of the Params of the innermost RegRouter
+// that matched the request, no RegRouter having it as its own key
+var innermostKey = new(ctxKey)
+
+// RegRouter is the RegRouter instance. Routes may be added while it serves
+// requests.
 type RegRouter struct {
-	Routes   []Route
-	CTX      struct{}
-	Handlers Handlers
+	Handlers   Handlers
+	ctx        *ctxKey
+	ctxOnce    sync.Once
+	middleware []Middleware
+	mu         sync.RWMutex
+	routes     []Route
+	generation uint64 // Incremented on every change to the routes.
+	index      routeIndex
+	regexes    map[string]*regexp.Regexp // Compiled patterns of the routes by expression.
+	cache      *matchCache
+	draining   int32        // Set while new requests are rejected.
+	fallback   http.Handler // Handler of requests no route answers.
+	// Options
+	slashRedirect    bool
+	caseInsensitive  bool
+	rawPath          bool
+	cleanPath        bool
+	cleanRedirect    bool
+	maxBody          int64
+	corsDefaults     CORSDefaults
+	defaultHeaders   map[string]string
+	requestIDs       bool
+	maxMatchAttempts int
+	maxHeaderBytes   int
+	basePath         string
 }
 
+// Middleware wraps a handler with cross-cutting logic
+type Middleware func(http.Handler) http.Handler
+
 // Route is the http routes
 type Route struct {
-	method  string
-	regex   *regexp.Regexp
-	handler http.HandlerFunc
+	name        string
+	method      string
+	methods     []string
+	pattern     string
+	matcher     Matcher
+	compiled    bool // Whether the router compiled the matcher from the pattern.
+	hostname    string
+	host        *regexp.Regexp
+	handler     http.HandlerFunc
+	middleware  []Middleware
+	corsConfig  *CORSConfig
+	constraints map[string]*regexp.Regexp
+	glob        bool
+	prefix      bool
+	secure      bool
+	maxBody     int64    // Request body size limit, or 0 for the MaxBody limit.
+	consumes    []string // Content types of the requests matched, if limited.
+	produces    []string // Media types the requests matched must accept, if limited.
+	preload     []string // Links to preload sent with the response.
+	CORS        bool
+	Priority    int
+}
+
+// RouteInfo describes a registered route
+type RouteInfo struct {
+	Name    string
+	Host    string
+	Method  string
+	Pattern string
+	Prefix  bool
+	Secure  bool
 	CORS    bool
 }
 
+// RouteSpec declares a route for AddAll, its options matching those of the
+// Add methods
+type RouteSpec struct {
+	Method      string
+	Pattern     string
+	Handler     http.HandlerFunc
+	CORS        bool
+	Name        string                    // Name for building URLs, if any.
+	Host        string                    // Host pattern to match, as for AddHost, if any.
+	Middleware  []Middleware              // Middleware run for the route only.
+	Priority    int                       // Priority, as for AddWithPriority.
+	Prefix      bool                      // Match paths starting with the pattern, as for AddPrefix.
+	Glob        bool                      // Pattern is a glob, as for AddGlob.
+	Secure      bool                      // Only match HTTPS requests, as for AddSecure.
+	Constraints map[string]*regexp.Regexp // Capture constraints, as for AddWithConstraints.
+	MaxBody     int64                     // Request body size limit, as for AddMaxBody.
+	Consumes    []string                  // Content types matched, as for AddConsumes.
+	Produces    []string                  // Media types matched, as for AddProduces.
+}
+
 // Handlers are default error code handlers + CORS
 type Handlers struct {
-	CORS       func([]string, http.ResponseWriter, *http.Request)
+	CORS func([]string, http.ResponseWriter, *http.Request)
+	// ErrorCodes are the error responses by status code. The 405 data holds
+	// the "allowed" methods, the 500 data the recovered "exception" and the
+	// "stack" of a panic, with the request "method", "path" and "request_id"
+	// from X-Request-ID or generated.
 	ErrorCodes map[int]func(map[string]interface{}, http.ResponseWriter, *http.Request)
+	// Recovery replaces the 500 error handler for panics in middleware and
+	// handlers, receiving the recovered value and stack. The response may
+	// already have been started.
+	Recovery func(interface{}, []byte, http.ResponseWriter, *http.Request)
+	// Log is called after every request with the response status
+	Log func(r *http.Request, status int, duration time.Duration)
+	// Metrics is called after every request with the pattern of the route
+	// that handled it, or empty string if none did
+	Metrics func(pattern string, method string, status int, duration time.Duration)
+	// NotFound replaces the 404 error handler for requests matching no route
+	NotFound http.Handler
+	// MethodNotAllowed replaces the 405 error handler, receiving the methods
+	// the path allows
+	MethodNotAllowed func(allowed []string, w http.ResponseWriter, r *http.Request)
+	// MatchLimit is called for requests testing more patterns than
+	// MaxMatchAttempts allows, before their 404 response
+	MatchLimit func(r *http.Request)
 }
 
 // New returns a RegRouter instance
 func New() *RegRouter {
-	return &RegRouter{
+	rr := &RegRouter{
 		Handlers: Handlers{
-			// Default CORS response
-			CORS: func(methods []string, w http.ResponseWriter, r *http.Request) {
-				headers := w.Header()
-				headers.Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
-				headers.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
-				headers.Set("Access-Control-Allow-Credentials", "true")
-				headers.Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
-			},
-			// Default error handlers
-			ErrorCodes: map[int]func(map[string]interface{}, http.ResponseWriter, *http.Request){
-				404: func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
-					code := http.StatusNotFound
-					http.Error(w, fmt.Sprintf("%d - %s\n", code, http.StatusText(code)), code)
-				},
-				405: func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
-					code := http.StatusMethodNotAllowed
-					w.Header().Set("Allow", data["allowed"].(string))
-					http.Error(w, fmt.Sprintf("%d - %s (Valid: %s)\n", code, http.StatusText(code), data["allowed"]), code)
-				},
-				500: func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
-					code := http.StatusInternalServerError
-					http.Error(w, fmt.Sprintf("%d - %s (Exception: %s)\n", code, http.StatusText(code), data["exception"]), code)
-				},
-			},
+			ErrorCodes: map[int]func(map[string]interface{}, http.ResponseWriter, *http.Request){},
+		},
+		corsDefaults: CORSDefaults{
+			AllowHeaders:     []string{"Accept", "Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization"},
+			AllowCredentials: true,
 		},
 	}
+
+	// Default error handlers, responding in JSON if the request prefers it
+	rr.Handlers.ErrorCodes[http.StatusNotFound] = func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+		rr.negotiateError(http.StatusNotFound, "", nil, w, r)
+	}
+	rr.Handlers.ErrorCodes[http.StatusMethodNotAllowed] = func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+		allowed, _ := data["allowed"].(string)
+		w.Header().Set("Allow", allowed)
+		rr.negotiateError(http.StatusMethodNotAllowed, "Valid: "+allowed, map[string]interface{}{"allowed": strings.Split(allowed, ", ")}, w, r)
+	}
+	rr.Handlers.ErrorCodes[http.StatusNotAcceptable] = func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+		rr.negotiateError(http.StatusNotAcceptable, "", nil, w, r)
+	}
+	rr.Handlers.ErrorCodes[http.StatusInternalServerError] = func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+		exception := fmt.Sprint(data["exception"])
+		rr.negotiateError(http.StatusInternalServerError, "Exception: "+exception, map[string]interface{}{"exception": exception}, w, r)
+	}
+	rr.Handlers.ErrorCodes[http.StatusRequestEntityTooLarge] = func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+		rr.negotiateError(http.StatusRequestEntityTooLarge, "", nil, w, r)
+	}
+	rr.Handlers.ErrorCodes[http.StatusRequestHeaderFieldsTooLarge] = func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+		rr.negotiateError(http.StatusRequestHeaderFieldsTooLarge, "", nil, w, r)
+	}
+	rr.Handlers.ErrorCodes[http.StatusServiceUnavailable] = func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+		rr.negotiateError(http.StatusServiceUnavailable, "", nil, w, r)
+	}
+	rr.Handlers.ErrorCodes[http.StatusGatewayTimeout] = func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+		rr.negotiateError(http.StatusGatewayTimeout, "", nil, w, r)
+	}
+
+	// Default CORS response
+	rr.Handlers.CORS = rr.defaultCORS
+	return rr
 }
 
 // Handler returns an HTTP handler
 func (rr *RegRouter) Handler() http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Attempt to recovery from any errors for a 500 error response
+	return rr
+}
+
+// ServeHTTP dispatches the request to the matching route
+func (rr *RegRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	rw, w := wrapWriter(w)
+	var pattern string // Pattern of the route handling the request.
+
+	// Identify the request, echoing the ID in the response
+	if rr.requestIDs {
+		id := requestID(r)
+		w.Header().Set("X-Request-ID", id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+	}
+
+	// Log and measure the request once it has been handled, panics included
+	if rr.Handlers.Log != nil || rr.Handlers.Metrics != nil {
 		defer func() {
-			if err := recover(); err != nil {
-				rr.Handlers.ErrorCodes[500](map[string]interface{}{"exception": err}, w, r)
+			duration := time.Since(start)
+			if rr.Handlers.Log != nil {
+				rr.Handlers.Log(r, rw.statusCode(), duration)
 			}
-		}()
 
-		var (
-			methods []string // Allowed CORS methods.
-			allowed []string // Allowed request methods.
-			params  = Params{map[string]string{}}
-		)
-
-		// Loop each route.
-		for _, route := range rr.Routes {
-			// Test each route for matching regex to the request URL path.
-			matches := route.regex.FindStringSubmatch(r.URL.Path)
-			if len(matches) > 0 {
-				// Add the method to the allowed CORS request methods
-				if route.CORS {
-					methods = append(methods, route.method)
-					if !strings.EqualFold(r.Method, route.method) {
-						allowed = append(allowed, route.method)
-						continue
-					}
-
-					// Send CORS headers
-					rr.Handlers.CORS(methods, w, r)
-				}
-
-				// Build a list url params based on named regex AND/OR index
-				for i, name := range route.regex.SubexpNames() {
-					params.Set(map[bool]string{
-						true:  fmt.Sprintf("%d", i),
-						false: name,
-					}[len(name) == 0], matches[i])
-				}
-
-				// Run the request handler
-				route.handler(w, r.WithContext(context.WithValue(r.Context(), rr.CTX, params)))
-				return
+			if rr.Handlers.Metrics != nil {
+				rr.Handlers.Metrics(pattern, r.Method, rw.statusCode(), duration)
 			}
+		}()
+	}
+
+	// Attempt to recovery from any errors for a 500 error response
+	defer func() {
+		if err := recover(); err != nil {
+			rr.panicked(err, debug.Stack(), rw, r)
+		}
+	}()
+
+	for key, value := range rr.defaultHeaders {
+		w.Header().Set(key, value)
+	}
+
+	rr.serve(w, r, &pattern)
+}
+
+// panicked responds to a panic without writing over a started response, which
+// is aborted instead so the client can't mistake it for a complete one
+func (rr *RegRouter) panicked(err interface{}, stack []byte, rw *responseWriter, r *http.Request) {
+	if rr.Handlers.Recovery != nil {
+		rr.Handlers.Recovery(err, stack, rw, r)
+		return
+	} else if rw.written() || err == http.ErrAbortHandler {
+		panic(http.ErrAbortHandler)
+	}
+
+	rr.Error(http.StatusInternalServerError, map[string]interface{}{"exception": err, "stack": stack, "method": r.Method, "path": r.URL.Path, "request_id": requestID(r)}, rw, r)
+}
+
+// Error runs the handler registered for the error code, falling back to a
+// plain error response if none is registered
+func (rr *RegRouter) Error(code int, data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+	if id := rr.RequestID(r); id != "" {
+		withID := map[string]interface{}{"request_id": id}
+		for key, value := range data {
+			withID[key] = value
+		}
+
+		data = withID
+	}
+
+	if handler := rr.Handlers.ErrorCodes[code]; handler != nil {
+		handler(data, w, r)
+		return
+	}
+
+	if allowed, ok := data["allowed"].(string); ok {
+		w.Header().Set("Allow", allowed)
+	}
+
+	http.Error(w, fmt.Sprintf("%d - %s\n", code, http.StatusText(code)), code)
+}
+
+// serve matches the request against the routes and runs the handler, setting
+// the pattern of the route it runs
+func (rr *RegRouter) serve(w http.ResponseWriter, r *http.Request, pattern *string) {
+	// Reject new requests while draining
+	if atomic.LoadInt32(&rr.draining) != 0 {
+		rr.Error(http.StatusServiceUnavailable, map[string]interface{}{}, w, r)
+		return
+	}
+
+	// Reject requests with oversized headers
+	if rr.maxHeaderBytes > 0 && headerSize(r) > rr.maxHeaderBytes {
+		rr.Error(http.StatusRequestHeaderFieldsTooLarge, map[string]interface{}{}, w, r)
+		return
+	}
+
+	// Redirect to the cleaned path, and match below the base path only
+	normalized, path, cleaned, ok := rr.normalize(r)
+	if cleaned != "" {
+		redirect(w, r, cleaned, rr.rawPath)
+		return
+	} else if !ok {
+		rr.notFound(w, r)
+		return
+	}
+
+	r = normalized
+
+	rr.mu.RLock()
+	routes, match := rr.routes, rr.matcher(path)
+	rr.mu.RUnlock()
+
+	host := hostname(r.Host)
+
+	// Answer CORS preflight requests without running a handler
+	if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" && rr.preflight(routes, host, w, r) {
+		return
+	}
+
+	// Run the route answering the request, a GET route answering a HEAD
+	// request, whose body the server discards
+	i, matches, allowed, unacceptable := rr.find(routes, &match, host, r)
+	if i >= 0 {
+		*pattern = routes[i].pattern
+		rr.dispatch(routes, i, matches, rr.middleware, w, r)
+		return
+	}
+
+	// Give up on requests testing too many patterns
+	if match.exceeded {
+		if rr.Handlers.MatchLimit != nil {
+			rr.Handlers.MatchLimit(r)
 		}
 
-		// Handle CORS or provide a list of allowed request methods
-		if len(methods) > 0 {
-			rr.Handlers.CORS(methods, w, r)
+		rr.notFound(w, r)
+		return
+	}
+
+	// Refuse requests matching a route except for the Accept header
+	if unacceptable {
+		rr.Error(http.StatusNotAcceptable, map[string]interface{}{}, w, r)
+		return
+	}
+
+	// Answer other OPTIONS requests with the allowed request methods, CORS
+	// routes like preflight requests
+	if r.Method == http.MethodOptions && len(allowed) > 0 {
+		allowed = appendUnique(allowed, http.MethodOptions)
+		sort.Strings(allowed)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		if !rr.preflight(routes, host, w, r) {
 			w.WriteHeader(http.StatusNoContent)
-			return
-		} else if len(allowed) > 0 {
-			rr.Handlers.ErrorCodes[405](map[string]interface{}{"allowed": strings.Join(allowed, ", ")}, w, r)
+		}
+
+		return
+	}
+
+	// Provide a list of allowed request methods
+	if len(allowed) > 0 {
+		sort.Strings(allowed)
+		if rr.Handlers.MethodNotAllowed != nil {
+			rr.Handlers.MethodNotAllowed(allowed, w, r)
 			return
 		}
 
-		// Handle a 404 error message
-		rr.Handlers.ErrorCodes[404](map[string]interface{}{}, w, r)
-	})
+		rr.Error(http.StatusMethodNotAllowed, map[string]interface{}{"allowed": strings.Join(allowed, ", ")}, w, r)
+		return
+	}
+
+	// Redirect to the path with or without the trailing slash if it matches
+	if rr.slashRedirect && path != "/" {
+		toggled := path + "/"
+		if strings.HasSuffix(path, "/") {
+			toggled = strings.TrimSuffix(path, "/")
+		}
+
+		for _, route := range routes {
+			if route.matchHost(host) && route.matchScheme(r) && route.match(toggled) != nil {
+				redirect(w, r, rr.basePath+toggled, rr.rawPath)
+				return
+			}
+		}
+	}
+
+	// Delegate requests no route answers, or else handle a 404 error message
+	if rr.fallback != nil {
+		rr.fallback.ServeHTTP(w, r)
+		return
+	}
+
+	rr.notFound(w, r)
+}
+
+// notFound responds with the NotFound handler or else a 404 error
+func (rr *RegRouter) notFound(w http.ResponseWriter, r *http.Request) {
+	if rr.Handlers.NotFound != nil {
+		rr.Handlers.NotFound.ServeHTTP(w, r)
+		return
+	}
+
+	rr.Error(http.StatusNotFound, map[string]interface{}{}, w, r)
+}
+
+// Match returns the route a request with the method and path would run, along
+// with its Params, without running it. The path may have a query string. It
+// is cleaned and has the base path removed as a request path would, a path
+// that would be redirected matching like the path redirected to. Routes
+// restricted to a host or to HTTPS don't match.
+func (rr *RegRouter) Match(method string, path string) (RouteInfo, Params, bool) {
+	u, err := url.Parse(path)
+	if err != nil {
+		return RouteInfo{}, Params{}, false
+	}
+
+	r, path, _, ok := rr.normalize(&http.Request{Method: strings.ToUpper(method), URL: u, Header: http.Header{}})
+	if !ok {
+		return RouteInfo{}, Params{}, false
+	}
+
+	rr.mu.RLock()
+	routes, match := rr.routes, rr.matcher(path)
+	rr.mu.RUnlock()
+
+	i, matches, _, _ := rr.find(routes, &match, "", r)
+	if i < 0 {
+		return RouteInfo{}, Params{}, false
+	}
+
+	return routes[i].info(), rr.params(routes[i], matches, r), true
+}
+
+// normalize returns the request with its path cleaned if CleanPath is enabled
+// and the base path removed, and the path routes match. It reports whether
+// the path is under the base path, and returns the cleaned path to redirect
+// to if CleanPath redirects.
+func (rr *RegRouter) normalize(r *http.Request) (*http.Request, string, string, bool) {
+	path, redirectTo := rr.path(r), ""
+	if rr.cleanPath {
+		if cleaned := cleanPath(path); cleaned != path {
+			if rr.cleanRedirect {
+				redirectTo = cleaned
+			}
+
+			r = withPath(r, cleaned, rr.rawPath)
+			path = cleaned
+		}
+	}
+
+	if rr.basePath == "" {
+		return r, path, redirectTo, true
+	} else if path == rr.basePath {
+		path = "/"
+	} else if strings.HasPrefix(path, rr.basePath+"/") {
+		path = strings.TrimPrefix(path, rr.basePath)
+	} else {
+		return r, path, redirectTo, false
+	}
+
+	return withPath(r, path, rr.rawPath), path, redirectTo, true
+}
+
+// find returns the index of the first route answering the request and its
+// regex matches, or else -1, the methods allowed for the path and whether a
+// route for the method failed the Accept header
+func (rr *RegRouter) find(routes []Route, match *matcher, host string, r *http.Request) (int, []string, []string, bool) {
+	var (
+		allowed      []string // Allowed request methods.
+		get          = -1     // GET route answering a HEAD request.
+		getMatches   []string
+		unacceptable bool // Whether a route for the method failed the Accept header.
+	)
+	defer match.save()
+
+	// Loop each route matching the request URL path.
+	for i, matches, ok := match.next(); ok; i, matches, ok = match.next() {
+		route := &routes[i]
+		if !route.matchHost(host) || !route.matchScheme(r) || !route.matchContentType(r) {
+			continue
+		}
+
+		if !route.matchAccept(r) {
+			unacceptable = unacceptable || route.allows(r.Method) || r.Method == http.MethodHead && route.allows(http.MethodGet)
+			continue
+		}
+
+		// Keep track of the methods valid for this path
+		if !route.allows(r.Method) {
+			// Answer a HEAD request with the first GET route unless a HEAD route matches
+			if r.Method == http.MethodHead && route.allows(http.MethodGet) && get < 0 {
+				get, getMatches = i, matches
+			}
+
+			allowed = appendUnique(allowed, route.methods...)
+			continue
+		}
+
+		return i, matches, nil, false
+	}
+
+	if get >= 0 {
+		return get, getMatches, nil, false
+	}
+
+	return -1, nil, allowed, unacceptable
+}
+
+// preflight answers a CORS preflight request with the methods of the CORS
+// routes matching the path, reporting whether there were any
+func (rr *RegRouter) preflight(routes []Route, host string, w http.ResponseWriter, r *http.Request) bool {
+	methods, config := corsRoutes(routes, host, rr.path(r))
+	if len(methods) == 0 {
+		return false
+	}
+
+	rr.cors(config, methods, w, r)
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// dispatch runs the handler of the route at the index for the regex matches,
+// wrapped in the route middleware and then the given router middleware
+func (rr *RegRouter) dispatch(routes []Route, index int, matches []string, middleware []Middleware, w http.ResponseWriter, r *http.Request) {
+	route := routes[index]
+
+	// Send CORS headers advertising the methods of every CORS route for this path
+	if route.CORS {
+		methods, _ := corsRoutes(routes, hostname(r.Host), rr.path(r))
+		rr.cors(route.corsConfig, methods, w, r)
+	}
+
+	for _, link := range route.preload {
+		w.Header().Add("Link", "<"+link+">; rel=preload")
+	}
+
+	// Run the request handler wrapped in the middleware, limiting the body
+	params := rr.params(route, matches, r)
+	params.routes, params.index = routes, index
+	handler := chain(chain(route.handler, route.middleware), middleware)
+	r = rr.withParams(r, params)
+	if limit := route.maxBody; limit > 0 || rr.maxBody > 0 {
+		if limit == 0 {
+			limit = rr.maxBody
+		}
+
+		rr.limitBody(limit, handler, w, r)
+		return
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// params returns the Params of the request for the route's regex matches
+func (rr *RegRouter) params(route Route, matches []string, r *http.Request) Params {
+	regex := route.regex()
+	if regex == nil {
+		return rr.matcherParams(route, matches, r)
+	}
+
+	// A literal route matched its pattern as is
+	var params Params
+	if matches == nil {
+		params = Params{Values: make(map[string]string, 1), all: map[string][]string{}}
+		params.addMatch("0", route.pattern, rr.rawPath)
+	} else {
+		params = regexParams(regex, matches, rr.rawPath)
+	}
+
+	params.route, params.routed = route.info(), true
+	if r.URL.RawQuery != "" {
+		params.query = r.URL.Query()
+	}
+
+	route.hostParams(&params, r)
+	return params
+}
+
+// ExtractParams returns the params a path matching the pattern has, without a
+// router or request
+func ExtractParams(pattern string, path string) (Params, bool) {
+	regex, err := regexp.Compile("^" + expandCatchAll(pattern) + "$")
+	if err != nil {
+		return Params{}, false
+	}
+
+	matches := regex.FindStringSubmatch(path)
+	if matches == nil {
+		return Params{}, false
+	}
+
+	return regexParams(regex, matches, false), true
+}
+
+// regexParams returns the params of the regex matches, decoding them if they
+// were matched in the escaped path
+func regexParams(regex *regexp.Regexp, matches []string, decode bool) Params {
+	names := regex.SubexpNames()
+	params := Params{Values: make(map[string]string, len(names)), all: map[string][]string{}, matches: matches}
+
+	// Build a list url params based on named regex AND/OR index
+	for i, name := range names {
+		key := map[bool]string{
+			true:  strconv.Itoa(i),
+			false: name,
+		}[len(name) == 0]
+
+		params.addMatch(key, matches[i], decode)
+	}
+
+	return params
+}
+
+// addMatch adds a matched param, decoding it if it was matched in the escaped
+// path and keeping the raw value
+func (p *Params) addMatch(key string, value string, decode bool) {
+	if decode {
+		if decoded, err := url.PathUnescape(value); err == nil && decoded != value {
+			if p.raw == nil {
+				p.raw = map[string]string{}
+			}
+
+			p.raw[key], value = value, decoded
+		}
+	}
+
+	p.Add(key, value)
+}
+
+// matcherParams returns the Params of the request for the captures of a
+// custom Matcher, which runs again on the matched path
+func (rr *RegRouter) matcherParams(route Route, matches []string, r *http.Request) Params {
+	captures, _ := route.matcher.Match(matches[0])
+	params := Params{Values: make(map[string]string, len(captures)), all: map[string][]string{}, route: route.info(), routed: true, matches: matches}
+	if r.URL.RawQuery != "" {
+		params.query = r.URL.Query()
+	}
+
+	for key, value := range captures {
+		params.Values[key] = value
+	}
+
+	route.hostParams(&params, r)
+	return params
+}
+
+// hostParams adds the named host captures, after the path params which take
+// precedence
+func (route Route) hostParams(params *Params, r *http.Request) {
+	if route.host == nil {
+		return
+	}
+
+	hostMatches := route.host.FindStringSubmatch(hostname(r.Host))
+	for i, name := range route.host.SubexpNames() {
+		if name != "" {
+			params.Add(name, hostMatches[i])
+		}
+	}
+}
+
+// setRoutes replaces the routes, the caller holding the lock
+func (rr *RegRouter) setRoutes(routes []Route) {
+	rr.routes = routes
+	rr.generation++
+	rr.index = newRouteIndex(routes)
+
+	// Keep the compiled patterns still in use for routes sharing them
+	rr.regexes = make(map[string]*regexp.Regexp, len(routes))
+	for _, route := range routes {
+		if route.compiled {
+			regex := route.regex()
+			rr.regexes[regex.String()] = regex
+		}
+	}
+}
+
+// Routes returns the registered routes in matching order
+func (rr *RegRouter) Routes() []RouteInfo {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	infos := make([]RouteInfo, len(rr.routes))
+	for i, route := range rr.routes {
+		infos[i] = route.info()
+	}
+
+	return infos
+}
+
+// Remove removes the routes registered with the method and pattern, reporting
+// whether any were removed
+func (rr *RegRouter) Remove(method string, pattern string) bool {
+	method = strings.ToUpper(method)
+
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	// Copy the routes so requests being served keep a consistent list
+	routes := make([]Route, 0, len(rr.routes))
+	for _, route := range rr.routes {
+		if route.method != method || route.pattern != pattern {
+			routes = append(routes, route)
+		}
+	}
+
+	removed := len(routes) != len(rr.routes)
+	rr.setRoutes(routes)
+	return removed
 }
 
-// Add adds a route to the RegRouter
+// Replace swaps the handler of the route registered with the method and
+// pattern, reporting whether it was found. A nil handler replaces nothing.
+func (rr *RegRouter) Replace(method string, pattern string, handler http.HandlerFunc) bool {
+	if handler == nil {
+		return false
+	}
+
+	method = strings.ToUpper(method)
+
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	for i, route := range rr.routes {
+		if route.method == method && route.pattern == pattern {
+			// Copy the routes so requests being served keep a consistent list
+			routes := append([]Route{}, rr.routes...)
+			routes[i].handler = handler
+			rr.setRoutes(routes)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Lookup returns the handler of the route registered with the method and
+// pattern, without its middleware
+func (rr *RegRouter) Lookup(method string, pattern string) (http.HandlerFunc, bool) {
+	method = strings.ToUpper(method)
+
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	for _, route := range rr.routes {
+		if route.method == method && route.pattern == pattern {
+			return route.handler, true
+		}
+	}
+
+	return nil, false
+}
+
+// Add adds a route to the RegRouter, panicking on an invalid pattern. A
+// pattern ending in /*name captures the rest of the path, slashes included, in
+// the name param. The method may be any method, such as WebDAV's PROPFIND, and
+// is uppercased, with * answering every method.
 func (rr *RegRouter) Add(method string, pattern string, handler http.HandlerFunc, cors bool) {
-	rr.Routes = append(rr.Routes, Route{strings.ToUpper(method), regexp.MustCompile("^" + pattern + "$"), handler, cors})
+	if err := rr.AddE(method, pattern, handler, cors); err != nil {
+		panic(err)
+	}
 }
 
-// Static servers static files
-func (rr *RegRouter) Static(path string, pattern string) {
-	rr.Add("GET", pattern, func(w http.ResponseWriter, r *http.Request) {
-		if file, err := rr.Params(r).GetE("filepath"); err == nil {
-			r.URL.Path = fmt.Sprintf("/%s", file)
-			http.FileServer(http.Dir(path)).ServeHTTP(w, r)
+// AddE adds a route to the RegRouter or returns why it can't be added
+func (rr *RegRouter) AddE(method string, pattern string, handler http.HandlerFunc, cors bool) error {
+	return rr.add(Route{method: method, handler: handler, CORS: cors}, pattern)
+}
+
+// AddAll adds the routes in order, or none of them, returning the error of the
+// first one that can't be added
+func (rr *RegRouter) AddAll(specs []RouteSpec) error {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	// Insert into a copy so a failing route leaves the routes unchanged
+	routes := append(make([]Route, 0, len(rr.routes)+len(specs)), rr.routes...)
+	for i, spec := range specs {
+		route := Route{
+			name:        spec.Name,
+			method:      spec.Method,
+			hostname:    spec.Host,
+			handler:     spec.Handler,
+			middleware:  spec.Middleware,
+			constraints: anchor(spec.Constraints),
+			glob:        spec.Glob,
+			prefix:      spec.Prefix,
+			secure:      spec.Secure,
+			maxBody:     spec.MaxBody,
+			consumes:    spec.Consumes,
+			produces:    spec.Produces,
+			CORS:        spec.CORS,
+			Priority:    spec.Priority,
 		}
+
+		route, err := rr.prepare(route, spec.Pattern)
+		if err == nil {
+			routes, err = insert(routes, route)
+		}
+
+		if err != nil {
+			return fmt.Errorf("route %d: %w", i, err)
+		}
+	}
+
+	rr.setRoutes(routes)
+	return nil
+}
+
+// AddMethods adds a route answering each of the methods, described by the
+// methods joined by ", " in its RouteInfo and for Remove and Replace
+func (rr *RegRouter) AddMethods(methods []string, pattern string, handler http.HandlerFunc, cors bool) {
+	if err := rr.add(Route{methods: append([]string{}, methods...), handler: handler, CORS: cors}, pattern); err != nil {
+		panic(err)
+	}
+}
+
+// AddWith adds a route wrapped in its own middleware, which runs inside any
+// RegRouter middleware
+func (rr *RegRouter) AddWith(method string, pattern string, handler http.HandlerFunc, cors bool, mw ...Middleware) {
+	if err := rr.add(Route{method: method, handler: handler, middleware: mw, CORS: cors}, pattern); err != nil {
+		panic(err)
+	}
+}
+
+// AddWithPriority adds a route matched before any route of a lower priority.
+// Routes of equal priority match in the order they were added, the default
+// priority being 0.
+func (rr *RegRouter) AddWithPriority(method string, pattern string, handler http.HandlerFunc, cors bool, priority int) {
+	if err := rr.add(Route{method: method, handler: handler, CORS: cors, Priority: priority}, pattern); err != nil {
+		panic(err)
+	}
+}
+
+// AddPrefix adds a route matching any path starting with the pattern. The
+// matched prefix is the "0" param.
+func (rr *RegRouter) AddPrefix(method string, pattern string, handler http.HandlerFunc, cors bool) {
+	if err := rr.add(Route{method: method, handler: handler, prefix: true, CORS: cors}, pattern); err != nil {
+		panic(err)
+	}
+}
+
+// AddNamed adds a route with a name to build its URL from
+func (rr *RegRouter) AddNamed(name string, method string, pattern string, handler http.HandlerFunc, cors bool) {
+	if err := rr.add(Route{name: name, method: method, handler: handler, CORS: cors}, pattern); err != nil {
+		panic(err)
+	}
+}
+
+// AddHost adds a route only matching requests for the host, a pattern
+// matched case-insensitively against the request host without its port. Named
+// host captures are added to the Params after any path param of the same name.
+func (rr *RegRouter) AddHost(host string, method string, pattern string, handler http.HandlerFunc, cors bool) {
+	if err := rr.add(Route{method: method, hostname: host, handler: handler, CORS: cors}, pattern); err != nil {
+		panic(err)
+	}
+}
+
+// AddSecure adds a route only matching HTTPS requests, made over TLS or
+// forwarded by a proxy with the X-Forwarded-Proto header
+func (rr *RegRouter) AddSecure(method string, pattern string, handler http.HandlerFunc, cors bool) {
+	if err := rr.add(Route{method: method, handler: handler, secure: true, CORS: cors}, pattern); err != nil {
+		panic(err)
+	}
+}
+
+// AddDeprecated adds a route responding with a Deprecation header, and a
+// Sunset header unless the sunset time is zero
+func (rr *RegRouter) AddDeprecated(method string, pattern string, handler http.HandlerFunc, cors bool, sunset time.Time) {
+	if err := rr.add(Route{method: method, handler: handler, middleware: []Middleware{deprecated(sunset)}, CORS: cors}, pattern); err != nil {
+		panic(err)
+	}
+}
+
+// AddMatcher adds a route matching paths with a custom Matcher, its captures
+// becoming the Params. The pattern describes the route.
+func (rr *RegRouter) AddMatcher(method string, pattern string, matcher Matcher, handler http.HandlerFunc, cors bool) {
+	if err := rr.add(Route{method: method, matcher: matcher, handler: handler, CORS: cors}, pattern); err != nil {
+		panic(err)
+	}
+}
+
+// AddPreload adds a route whose responses have a Link header to preload each
+// of the links, such as the assets of a page
+func (rr *RegRouter) AddPreload(method string, pattern string, handler http.HandlerFunc, cors bool, links ...string) {
+	if err := rr.add(Route{method: method, handler: handler, preload: links, CORS: cors}, pattern); err != nil {
+		panic(err)
+	}
+}
+
+// AddWithConstraints adds a route whose captures must also wholly match the
+// constraint for their name, or index if unnamed, for the route to match
+func (rr *RegRouter) AddWithConstraints(method string, pattern string, handler http.HandlerFunc, cors bool, constraints map[string]*regexp.Regexp) {
+	if err := rr.add(Route{method: method, handler: handler, constraints: anchor(constraints), CORS: cors}, pattern); err != nil {
+		panic(err)
+	}
+}
+
+// anchor returns the constraints anchored to wholly match the captures, or nil
+// without any
+func anchor(constraints map[string]*regexp.Regexp) map[string]*regexp.Regexp {
+	if constraints == nil {
+		return nil
+	}
+
+	anchored := map[string]*regexp.Regexp{}
+	for key, constraint := range constraints {
+		anchored[key] = regexp.MustCompile("^(?:" + constraint.String() + ")$")
+	}
+
+	return anchored
+}
+
+// add compiles the pattern and inserts the route by priority
+func (rr *RegRouter) add(route Route, pattern string) error {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	route, err := rr.prepare(route, pattern)
+	if err != nil {
+		return err
+	}
+
+	routes, err := insert(rr.routes, route)
+	if err != nil {
+		return err
+	}
+
+	rr.setRoutes(routes)
+	return nil
+}
+
+// prepare validates the route and compiles its patterns, the caller holding
+// the lock
+func (rr *RegRouter) prepare(route Route, pattern string) (Route, error) {
+	if route.handler == nil {
+		return route, fmt.Errorf("%q: %w", pattern, ErrNilHandler)
+	}
+
+	if route.hostname != "" && route.host == nil {
+		regex, err := regexp.Compile("(?i)^" + route.hostname + "$")
+		if err != nil {
+			return route, fmt.Errorf("%q: invalid host pattern: %w", route.hostname, err)
+		}
+
+		route.host = regex
+	}
+
+	// Compile the pattern unless the route has a custom matcher
+	route.pattern = pattern
+	if route.matcher == nil {
+		regex, err := rr.compile(route)
+		if err != nil {
+			return route, fmt.Errorf("%q: invalid pattern: %w", pattern, err)
+		}
+
+		route.matcher, route.compiled = RegexMatcher{regex}, true
+	}
+
+	// Routes answering several methods are described by the joined methods
+	if route.methods == nil {
+		route.methods = []string{route.method}
+	}
+
+	for i, method := range route.methods {
+		route.methods[i] = strings.ToUpper(method)
+	}
+
+	route.method = strings.Join(route.methods, ", ")
+	return route, nil
+}
+
+// insert returns the routes with the route inserted by priority, or why it
+// can't be
+func insert(routes []Route, route Route) ([]Route, error) {
+	// Reject a route an existing one would always match first
+	for _, existing := range routes {
+		if method, ok := existing.duplicates(route); ok && existing.Priority >= route.Priority {
+			return nil, fmt.Errorf("%q: %s: %w", route.pattern, method, ErrDuplicateRoute)
+		}
+	}
+
+	// Find the position after every route of the same or a higher priority
+	i := len(routes)
+	for i > 0 && routes[i-1].Priority < route.Priority {
+		i--
+	}
+
+	if i == len(routes) {
+		return append(routes, route), nil
+	}
+
+	// Copy the routes so requests being served keep a consistent list
+	inserted := make([]Route, 0, len(routes)+1)
+	return append(append(append(inserted, routes[:i]...), route), routes[i:]...), nil
+}
+
+// compile compiles the route pattern matching the whole path, or its start
+// for a prefix pattern, the caller holding the lock
+func (rr *RegRouter) compile(route Route) (*regexp.Regexp, error) {
+	expr, err := route.expr()
+	if err != nil {
+		return nil, err
+	}
+
+	expr = "^" + expr
+	if !route.prefix {
+		expr += "$"
+	}
+
+	if rr.caseInsensitive {
+		expr = "(?i)" + expr
+	}
+
+	// Share the regex of routes with the same pattern
+	if regex, ok := rr.regexes[expr]; ok {
+		return regex, nil
+	}
+
+	regex, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	if rr.regexes == nil {
+		rr.regexes = map[string]*regexp.Regexp{}
+	}
+
+	rr.regexes[expr] = regex
+	return regex, nil
+}
+
+// Use appends middleware to the RegRouter. Middleware runs in the order it was
+// added, after a route has matched, so Params are available to it. Context
+// values the middleware adds to the request reach the handler along with the
+// Params. Panics in middleware are recovered like those in handlers.
+func (rr *RegRouter) Use(mw ...Middleware) {
+	rr.middleware = append(rr.middleware, mw...)
+}
+
+// NotFound sets the handler for requests matching no route
+func (rr *RegRouter) NotFound(h http.Handler) {
+	rr.Handlers.NotFound = h
+}
+
+// Fallback sets a handler for the requests no route answers, run instead of a
+// 404 response. Requests to a path that doesn't allow their method still get a
+// 405 response.
+func (rr *RegRouter) Fallback(h http.Handler) {
+	rr.fallback = h
+}
+
+// MethodNotAllowed sets the handler for requests to a path that doesn't allow
+// the request method
+func (rr *RegRouter) MethodNotAllowed(h func(allowed []string, w http.ResponseWriter, r *http.Request)) {
+	rr.Handlers.MethodNotAllowed = h
+}
+
+// Drain makes the RegRouter respond to new requests with a 503 error, letting
+// those in flight finish
+func (rr *RegRouter) Drain() {
+	atomic.StoreInt32(&rr.draining, 1)
+}
+
+// Resume makes a draining RegRouter serve requests again
+func (rr *RegRouter) Resume() {
+	atomic.StoreInt32(&rr.draining, 0)
+}
+
+// MatchRawPath sets whether routes match the escaped request path instead of
+// the decoded one, which is disabled by default. This lets a param contain an
+// encoded slash, the params being decoded once matched.
+func (rr *RegRouter) MatchRawPath(raw bool) {
+	rr.rawPath = raw
+}
+
+// SetDefaultHeaders sets headers written on every response before the
+// handler runs, such as security headers. Handlers may override them.
+func (rr *RegRouter) SetDefaultHeaders(headers map[string]string) {
+	rr.defaultHeaders = make(map[string]string, len(headers))
+	for key, value := range headers {
+		rr.defaultHeaders[key] = value
+	}
+}
+
+// BasePath sets the path prefix the router is served under, such as by a
+// reverse proxy, which is removed from request paths before matching. Paths
+// not under it get a 404 error.
+func (rr *RegRouter) BasePath(base string) {
+	rr.basePath = strings.TrimSuffix("/"+strings.Trim(base, "/"), "/")
+}
+
+// CleanPath sets whether request paths are cleaned before matching, collapsing
+// repeated slashes and resolving dot segments, which is disabled by default.
+// A request to an unclean path is redirected to the cleaned one if redirect is
+// set, or else served as if it had the cleaned path.
+func (rr *RegRouter) CleanPath(clean bool, redirect bool) {
+	rr.cleanPath, rr.cleanRedirect = clean, redirect
+}
+
+// StrictSlash sets whether paths differing by a trailing slash are distinct,
+// the default. When disabled a request that matches no route is redirected to
+// the path with the trailing slash toggled if a route matches that instead.
+func (rr *RegRouter) StrictSlash(strict bool) {
+	rr.slashRedirect = !strict
+}
+
+// CaseInsensitive sets whether routes match paths regardless of case, which is
+// disabled by default. Existing routes are recompiled.
+func (rr *RegRouter) CaseInsensitive(insensitive bool) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	rr.caseInsensitive = insensitive
+
+	// Copy the routes so requests being served keep a consistent list
+	routes := append([]Route{}, rr.routes...)
+	for i := range routes {
+		if !routes[i].compiled {
+			continue
+		}
+
+		// The pattern already compiled, so the flag can't make it invalid
+		regex, _ := rr.compile(routes[i])
+		routes[i].matcher = RegexMatcher{regex}
+	}
+
+	rr.setRoutes(routes)
+}
+
+// Mount serves a sub-router under a pattern prefix, matching the prefix itself
+// and any path below it. The sub-router sees the path with the prefix removed.
+// A trailing slash on the prefix is ignored.
+func (rr *RegRouter) Mount(prefix string, sub *RegRouter) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	index := strconv.Itoa(regexp.MustCompile(prefix).NumSubexp() + 1)
+	rr.Any(prefix+"(/.*)?", func(w http.ResponseWriter, r *http.Request) {
+		params := rr.Params(r)
+		path, raw := params.Get(index), params.raw[index]
+		if path == "" {
+			path = "/"
+		}
+
+		mounted := new(http.Request)
+		*mounted = *r
+		mounted.URL = new(url.URL)
+		*mounted.URL = *r.URL
+		mounted.URL.Path = path
+		mounted.URL.RawPath = raw
+		sub.ServeHTTP(w, mounted)
 	}, false)
 }
 
-// Params is a helper to get request parameters
+// Params is a helper to get request parameters, empty if no route matched
 func (rr *RegRouter) Params(r *http.Request) Params {
-	return r.Context().Value(rr.CTX).(Params)
+	if params, ok := r.Context().Value(rr.key()).(Params); ok {
+		return params
+	}
+
+	return Params{Values: map[string]string{}, query: r.URL.Query()}
+}
+
+// ParamsMerged returns the Params of the request merged with those of any
+// enclosing RegRouter, such as one mounting this one. The params of the inner
+// router win.
+func (rr *RegRouter) ParamsMerged(r *http.Request) Params {
+	params := rr.Params(r)
+	if params.parent == nil {
+		return params
+	}
+
+	merged := params
+	merged.Values, merged.all, merged.raw = map[string]string{}, map[string][]string{}, map[string]string{}
+	for p := &params; p != nil; p = p.parent {
+		for key, value := range p.Values {
+			if _, ok := merged.Values[key]; ok {
+				continue
+			}
+
+			merged.Values[key] = value
+			if all, ok := p.all[key]; ok {
+				merged.all[key] = all
+			}
+
+			if raw, ok := p.raw[key]; ok {
+				merged.raw[key] = raw
+			}
+		}
+	}
+
+	return merged
+}
+
+// Next runs the next route matching the request after the one running, from
+// within its handler, as if that route hadn't matched. The router middleware
+// isn't run again, only the middleware of the next route. The request should
+// be the one the handler got. Without a next route, the request is delegated
+// to the Fallback handler or else gets a 404 error.
+func (rr *RegRouter) Next(w http.ResponseWriter, r *http.Request) {
+	params, ok := r.Context().Value(rr.key()).(Params)
+	if ok && params.routes != nil {
+		// Test the later routes of the same list in order
+		later := make([]int, 0, len(params.routes)-params.index-1)
+		for i := params.index + 1; i < len(params.routes); i++ {
+			later = append(later, i)
+		}
+
+		match := matcher{routes: params.routes, path: rr.path(r)}
+		match.lists[2] = later
+		if i, matches, _, _ := rr.find(params.routes, &match, hostname(r.Host), r); i >= 0 {
+			rr.dispatch(params.routes, i, matches, nil, w, r)
+			return
+		}
+	}
+
+	if rr.fallback != nil {
+		rr.fallback.ServeHTTP(w, r)
+		return
+	}
+
+	rr.notFound(w, r)
+}
+
+// Matches returns the regex submatches of the route that matched the request,
+// the whole match first, or nil if none did
+func (rr *RegRouter) Matches(r *http.Request) []string {
+	params, ok := r.Context().Value(rr.key()).(Params)
+	if !ok {
+		return nil
+	}
+
+	// A literal route matched its pattern as is
+	if params.matches == nil && params.routed {
+		return []string{params.route.Pattern}
+	}
+
+	return params.matches
+}
+
+// WithParams returns a shallow copy of the request carrying the params, for
+// running a handler directly as if a route had matched them
+func (rr *RegRouter) WithParams(r *http.Request, values map[string]string) *http.Request {
+	params := Params{Values: make(map[string]string, len(values)), all: map[string][]string{}}
+	for key, value := range values {
+		params.Values[key] = value
+	}
+
+	if r.URL != nil && r.URL.RawQuery != "" {
+		params.query = r.URL.Query()
+	}
+
+	return rr.withParams(r, params)
+}
+
+// Route returns the route that matched the request, if any
+func (rr *RegRouter) Route(r *http.Request) (RouteInfo, bool) {
+	if params, ok := r.Context().Value(rr.key()).(Params); ok && params.routed {
+		return params.route, true
+	}
+
+	return RouteInfo{}, false
+}
+
+// path returns the request path routes match
+func (rr *RegRouter) path(r *http.Request) string {
+	if rr.rawPath {
+		return r.URL.EscapedPath()
+	}
+
+	return r.URL.Path
+}
+
+// key returns the unique context key of the RegRouter, assigned on first use
+// so that routers not made by New get their own key too
+func (rr *RegRouter) key() *ctxKey {
+	rr.ctxOnce.Do(func() {
+		rr.ctx = new(ctxKey)
+	})
+
+	return rr.ctx
+}
+
+// withParams returns a shallow copy of the request carrying the params, linked
+// to the params of any enclosing RegRouter
+func (rr *RegRouter) withParams(r *http.Request, params Params) *http.Request {
+	ctx := r.Context()
+	params.router = rr.key()
+	if parent, ok := ctx.Value(innermostKey).(Params); ok && parent.router == params.router {
+		// Params replacing those of the same router, as for Next, keep their parent
+		params.parent = parent.parent
+	} else if ok {
+		params.parent = new(Params)
+		*params.parent = parent
+	}
+
+	return r.WithContext(&paramsContext{ctx, rr.key(), params})
+}
+
+// paramsContext carries the Params of a RegRouter under its own key and the
+// innermost key, with a single allocation
+type paramsContext struct {
+	context.Context
+	key    *ctxKey
+	params interface{}
+}
+
+// Value returns the Params for the RegRouter key or the innermost key
+func (ctx *paramsContext) Value(key interface{}) interface{} {
+	if k, ok := key.(*ctxKey); ok && (k == ctx.key || k == innermostKey) {
+		return ctx.params
+	}
+
+	return ctx.Context.Value(key)
+}
+
+// info returns the RouteInfo describing the route
+func (route Route) info() RouteInfo {
+	return RouteInfo{Name: route.name, Host: route.hostname, Method: route.method, Pattern: route.pattern, Prefix: route.prefix, Secure: route.secure, CORS: route.CORS}
+}
+
+// corsRoutes returns the distinct methods of the CORS routes matching the host
+// and path, and the CORS config of the first configured one
+func corsRoutes(routes []Route, host string, path string) ([]string, *CORSConfig) {
+	var (
+		methods []string
+		config  *CORSConfig
+	)
+
+	for _, route := range routes {
+		if route.CORS && route.matchHost(host) && route.match(path) != nil {
+			methods = appendUnique(methods, route.methods...)
+			if config == nil {
+				config = route.corsConfig
+			}
+		}
+	}
+
+	return methods, config
+}
+
+// cleanPath cleans the path like path.Clean, keeping a trailing slash
+func cleanPath(p string) string {
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+
+	return cleaned
+}
+
+// withPath returns a shallow copy of the request with another path, escaped
+// or not
+func withPath(r *http.Request, path string, escaped bool) *http.Request {
+	changed := new(http.Request)
+	*changed = *r
+	changed.URL = new(url.URL)
+	*changed.URL = *r.URL
+	changed.URL.Path, changed.URL.RawPath = path, ""
+	if escaped {
+		changed.URL.Path, _ = url.PathUnescape(path)
+		changed.URL.RawPath = path
+	}
+
+	return changed
+}
+
+// redirect permanently redirects the request to path, escaped or not, keeping
+// the query and the request method
+func redirect(w http.ResponseWriter, r *http.Request, path string, escaped bool) {
+	code := http.StatusPermanentRedirect
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		code = http.StatusMovedPermanently
+	}
+
+	http.Redirect(w, r, withPath(r, path, escaped).URL.RequestURI(), code)
+}
+
+// match returns the regex matches of the path if it matches the route pattern
+// and constraints, or just the path for a custom Matcher
+func (route Route) match(path string) []string {
+	regex := route.regex()
+	if regex == nil {
+		captures, ok := route.matcher.Match(path)
+		if !ok {
+			return nil
+		}
+
+		for name, constraint := range route.constraints {
+			if !constraint.MatchString(captures[name]) {
+				return nil
+			}
+		}
+
+		return []string{path}
+	}
+
+	matches := regex.FindStringSubmatch(path)
+	if matches == nil || route.constraints == nil {
+		return matches
+	}
+
+	for i, name := range regex.SubexpNames() {
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+
+		if constraint, ok := route.constraints[name]; ok && !constraint.MatchString(matches[i]) {
+			return nil
+		}
+	}
+
+	return matches
+}
+
+// duplicates reports whether the routes match the same requests for a method,
+// returning the method
+func (route Route) duplicates(other Route) (string, bool) {
+	if route.pattern != other.pattern || route.glob != other.glob || route.prefix != other.prefix || route.secure != other.secure || route.hostname != other.hostname {
+		return "", false
+	}
+
+	// Constraints, content negotiation and custom matchers may tell them apart
+	if route.constraints != nil || other.constraints != nil || route.consumes != nil || other.consumes != nil || route.produces != nil || other.produces != nil || !route.compiled || !other.compiled {
+		return "", false
+	}
+
+	for _, method := range other.methods {
+		if contains(route.methods, method) {
+			return method, true
+		}
+	}
+
+	return "", false
+}
+
+// catchAll matches a trailing catch-all param in a pattern
+var catchAll = regexp.MustCompile(`/\*([A-Za-z_][A-Za-z0-9_]*)$`)
+
+// expr returns the regex of the route pattern, translated from a glob or with
+// the catch-all param expanded
+func (route Route) expr() (string, error) {
+	if route.glob {
+		return globExpr(route.pattern)
+	}
+
+	return expandCatchAll(route.pattern), nil
+}
+
+// expandCatchAll translates a trailing /*name in the pattern to a param named
+// name capturing the rest of the path, slashes included
+func expandCatchAll(pattern string) string {
+	return catchAll.ReplaceAllString(pattern, "/(?P<$1>.*)")
+}
+
+// allows reports whether the route answers the request method
+func (route Route) allows(method string) bool {
+	for _, m := range route.methods {
+		if m == methodAny || strings.EqualFold(method, m) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchHost reports whether the route serves the host
+func (route Route) matchHost(host string) bool {
+	return route.host == nil || route.host.MatchString(host)
+}
+
+// matchScheme reports whether the route serves the request scheme
+func (route Route) matchScheme(r *http.Request) bool {
+	if !route.secure || r.TLS != nil {
+		return true
+	}
+
+	proto := r.Header.Get("X-Forwarded-Proto")
+	if i := strings.IndexByte(proto, ','); i >= 0 {
+		proto = proto[:i]
+	}
+
+	return strings.EqualFold(strings.TrimSpace(proto), "https")
+}
+
+// hostname returns the request host without the port
+func hostname(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		return name
+	}
+
+	return host
+}
+
+// appendUnique appends the values to a list that aren't already present
+func appendUnique(list []string, values ...string) []string {
+	for _, value := range values {
+		if !contains(list, value) {
+			list = append(list, value)
+		}
+	}
+
+	return list
+}
+
+// contains reports whether a list contains a value
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// deprecated returns middleware setting the deprecation headers
+func deprecated(sunset time.Time) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", "true")
+			if !sunset.IsZero() {
+				w.Header().Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// chain wraps a handler in middleware, the first being the outermost
+func chain(handler http.Handler, middleware []Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
+	return handler
 }
@@ -0,0 +1,1538 @@
+package regrouter
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serve runs the request through the handler and returns the response
+func serve(h http.Handler, method string, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w
+}
+
+// okHandler responds with "ok"
+func okHandler(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, "ok")
+}
+
+func TestHeadAnsweredByGet(t *testing.T) {
+	rr := New()
+	rr.Get("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Page", "1")
+		io.WriteString(w, "<html>page</html>\n")
+	}, false)
+	rr.Get("/both", okHandler, false)
+	rr.Head("/both", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Head", "1")
+	}, false)
+
+	server := httptest.NewServer(rr)
+	defer server.Close()
+
+	get, err := http.Get(server.URL + "/page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	get.Body.Close()
+
+	head, err := http.Head(server.URL + "/page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	head.Body.Close()
+
+	if head.StatusCode != http.StatusOK || head.Header.Get("X-Page") != "1" {
+		t.Fatalf("HEAD /page: got %d %v", head.StatusCode, head.Header)
+	}
+	if got, want := head.Header.Get("Content-Type"), get.Header.Get("Content-Type"); got != want {
+		t.Errorf("HEAD /page Content-Type: got %q, want %q", got, want)
+	}
+	if head.ContentLength != get.ContentLength || head.ContentLength != 18 {
+		t.Errorf("HEAD /page Content-Length: got %d, want %d", head.ContentLength, get.ContentLength)
+	}
+
+	// A HEAD route takes precedence over the GET route
+	head, err = http.Head(server.URL + "/both")
+	if err != nil {
+		t.Fatal(err)
+	}
+	head.Body.Close()
+	if head.Header.Get("X-Head") != "1" {
+		t.Errorf("HEAD /both: HEAD route didn't run")
+	}
+
+	if w := serve(rr, http.MethodPost, "/page"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /page: got %d, want 405", w.Code)
+	}
+}
+
+func TestHeadKeepsFlusher(t *testing.T) {
+	rr := New()
+	flushes := false
+	rr.Get("/stream", func(w http.ResponseWriter, r *http.Request) {
+		_, flushes = w.(http.Flusher)
+	}, false)
+
+	serve(rr, http.MethodHead, "/stream")
+	if !flushes {
+		t.Error("HEAD /stream: writer isn't an http.Flusher")
+	}
+}
+
+func TestBasePath(t *testing.T) {
+	rr := New()
+	rr.BasePath("app/")
+	var got string
+	rr.Get("/", func(w http.ResponseWriter, r *http.Request) { got = "root" }, false)
+	rr.Get("/users/(?P<id>[0-9]+)", func(w http.ResponseWriter, r *http.Request) {
+		got = rr.Params(r).Get("id") + " " + r.URL.Path
+	}, false)
+	rr.Get("/dir/", okHandler, false)
+
+	if w := serve(rr, http.MethodGet, "/app/users/4"); w.Code != http.StatusOK || got != "4 /users/4" {
+		t.Errorf("GET /app/users/4: got %d %q", w.Code, got)
+	}
+	if w := serve(rr, http.MethodGet, "/app"); w.Code != http.StatusOK || got != "root" {
+		t.Errorf("GET /app: got %d %q", w.Code, got)
+	}
+	for _, path := range []string{"/users/4", "/application/users/4", "/"} {
+		if w := serve(rr, http.MethodGet, path); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s: got %d, want 404", path, w.Code)
+		}
+	}
+
+	// Redirects keep the base path
+	rr.StrictSlash(false)
+	if w := serve(rr, http.MethodGet, "/app/dir"); w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/app/dir/" {
+		t.Errorf("GET /app/dir: got %d %v", w.Code, w.Header())
+	}
+	rr.CleanPath(true, true)
+	if w := serve(rr, http.MethodGet, "/app//users/4"); w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/app/users/4" {
+		t.Errorf("GET /app//users/4: got %d %v", w.Code, w.Header())
+	}
+
+	rr.BasePath("/")
+	if w := serve(rr, http.MethodGet, "/users/4"); w.Code != http.StatusOK {
+		t.Errorf("GET /users/4 without a base path: got %d", w.Code)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	rr := New()
+	ran := false
+	rr.Get(`/u/(?P<id>\d+)`, func(w http.ResponseWriter, r *http.Request) { ran = true }, false)
+
+	info, params, found := rr.Match("get", "/u/5?x=1")
+	if !found || info.Pattern != `/u/(?P<id>\d+)` || params.Get("id") != "5" || params.Query("x") != "1" {
+		t.Errorf("Match of a route: got %+v %v %t", info, params.Values, found)
+	}
+	if ran {
+		t.Error("Match ran the handler")
+	}
+
+	tests := []struct {
+		method, path string
+		want         bool
+	}{
+		{http.MethodHead, "/u/5", true},
+		{http.MethodPost, "/u/5", false},
+		{http.MethodGet, "/x", false},
+	}
+	for _, test := range tests {
+		if _, _, found := rr.Match(test.method, test.path); found != test.want {
+			t.Errorf("Match(%q, %q): got %t, want %t", test.method, test.path, found, test.want)
+		}
+	}
+}
+
+func TestMatchNormalizesPath(t *testing.T) {
+	rr := New()
+	rr.Get("/u/(?P<id>[a-z]+)", okHandler, false)
+	rr.BasePath("/app")
+
+	for path, want := range map[string]bool{"/app/u/x": true, "/u/x": false} {
+		_, _, matched := rr.Match(http.MethodGet, path)
+		code := serve(rr, http.MethodGet, path).Code
+		if matched != want || (code == http.StatusOK) != want {
+			t.Errorf("%s: Match got %t, ServeHTTP got %d, want matched %t", path, matched, code, want)
+		}
+	}
+
+	rr.CleanPath(true, false)
+	if _, params, matched := rr.Match(http.MethodGet, "/app//u/./x"); !matched || params.Get("id") != "x" {
+		t.Errorf("Match of an unclean path: got %t %v", matched, params.Values)
+	}
+	rr.CleanPath(true, true)
+	if _, _, matched := rr.Match(http.MethodGet, "/app//u/x"); !matched {
+		t.Error("Match of a path redirected when cleaned: got false")
+	}
+}
+
+func TestMount(t *testing.T) {
+	for _, prefix := range []string{"/admin", "/admin/"} {
+		rr, admin := New(), New()
+		var got string
+		admin.Get("/", func(w http.ResponseWriter, r *http.Request) { got = "root" }, false)
+		admin.Post(`/users/(?P<id>\d+)`, func(w http.ResponseWriter, r *http.Request) {
+			got = admin.Params(r).Get("id") + " " + r.URL.Path
+		}, false)
+		rr.Mount(prefix, admin)
+
+		for _, path := range []string{"/admin", "/admin/"} {
+			got = ""
+			if serve(rr, http.MethodGet, path); got != "root" {
+				t.Errorf("Mount(%q) GET %s: got %q, want root", prefix, path, got)
+			}
+		}
+		if serve(rr, http.MethodPost, "/admin/users/3"); got != "3 /users/3" {
+			t.Errorf("Mount(%q) POST /admin/users/3: got %q", prefix, got)
+		}
+		if w := serve(rr, http.MethodGet, "/admin/users/3"); w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("Mount(%q) GET /admin/users/3: got %d, want 405", prefix, w.Code)
+		}
+		if w := serve(rr, http.MethodGet, "/adminx"); w.Code != http.StatusNotFound {
+			t.Errorf("Mount(%q) GET /adminx: got %d, want 404", prefix, w.Code)
+		}
+	}
+}
+
+func TestAddAll(t *testing.T) {
+	rr := New()
+	rr.Get("/existing", okHandler, false)
+
+	err := rr.AddAll([]RouteSpec{
+		{Method: http.MethodGet, Pattern: "/a", Handler: okHandler},
+		{Method: http.MethodGet, Pattern: "/b(", Handler: okHandler},
+	})
+	var syntaxErr *syntax.Error
+	if !errors.As(err, &syntaxErr) || !strings.HasPrefix(err.Error(), `route 1: "/b(": invalid pattern: `) {
+		t.Fatalf("AddAll with an invalid pattern: got %v, want the failing route", err)
+	}
+	if got := len(rr.Routes()); got != 1 {
+		t.Errorf("routes after a failed AddAll: got %d, want 1", got)
+	}
+	if w := serve(rr, http.MethodGet, "/a"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /a after a failed AddAll: got %d, want 404", w.Code)
+	}
+
+	// Duplicates within the specs are rejected too
+	if err := rr.AddAll([]RouteSpec{
+		{Method: http.MethodGet, Pattern: "/c", Handler: okHandler},
+		{Method: http.MethodGet, Pattern: "/c", Handler: okHandler},
+	}); !errors.Is(err, ErrDuplicateRoute) {
+		t.Errorf("AddAll with duplicate specs: got %v, want ErrDuplicateRoute", err)
+	}
+
+	var got string
+	record := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) { got = name }
+	}
+	tag := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Tag", "1")
+			next.ServeHTTP(w, r)
+		})
+	}
+	if err := rr.AddAll([]RouteSpec{
+		{Method: http.MethodGet, Pattern: "/p/.*", Handler: record("any")},
+		{Method: http.MethodGet, Pattern: "/p/top", Handler: record("top"), Priority: 1},
+		{Method: http.MethodGet, Pattern: "/api", Handler: record("api"), Host: `api\.example\.com`},
+		{Method: http.MethodGet, Pattern: "/static", Handler: record("static"), Prefix: true, Middleware: []Middleware{tag}},
+		{Method: http.MethodGet, Pattern: `/n/(?P<id>\w+)`, Handler: record("n"), Name: "n", Constraints: map[string]*regexp.Regexp{"id": regexp.MustCompile(`\d+`)}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if serve(rr, http.MethodGet, "/p/top"); got != "top" {
+		t.Errorf("GET /p/top: got %q, want top", got)
+	}
+	if w := serve(rr, http.MethodGet, "/api"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /api on another host: got %d, want 404", w.Code)
+	}
+	r := httptest.NewRequest(http.MethodGet, "http://api.example.com/api", nil)
+	if rr.ServeHTTP(httptest.NewRecorder(), r); got != "api" {
+		t.Errorf("GET /api on its host: got %q, want api", got)
+	}
+	if w := serve(rr, http.MethodGet, "/static/css/site.css"); got != "static" || w.Header().Get("X-Tag") != "1" {
+		t.Errorf("GET /static/css/site.css: got %q %v", got, w.Header())
+	}
+	if w := serve(rr, http.MethodGet, "/n/x"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /n/x: got %d, want 404", w.Code)
+	}
+	if url, err := rr.URL("n", map[string]string{"id": "7"}); err != nil || url != "/n/7" {
+		t.Errorf(`URL("n"): got %q, %v`, url, err)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	rr := New()
+	rr.Get("/", okHandler, false)
+
+	rr.Drain()
+	if w := serve(rr, http.MethodGet, "/"); w.Code != http.StatusServiceUnavailable || !strings.HasPrefix(w.Body.String(), "503 - ") {
+		t.Errorf("GET / while draining: got %d %q", w.Code, w.Body)
+	}
+
+	// The default handler responds in JSON when preferred
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept", "application/json")
+	rr.ServeHTTP(w, r)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("GET / accepting JSON while draining: got %d %v", w.Code, w.Header())
+	}
+
+	rr.Resume()
+	if w := serve(rr, http.MethodGet, "/"); w.Code != http.StatusOK {
+		t.Errorf("GET / after Resume: got %d, want 200", w.Code)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	rr := New()
+	rr.Get("/", okHandler, false)
+
+	if rr.Replace(http.MethodGet, "/", nil) {
+		t.Error("Replace with a nil handler: got true")
+	}
+	if w := serve(rr, http.MethodGet, "/"); w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Errorf("GET / after a nil Replace: got %d %q", w.Code, w.Body)
+	}
+
+	if !rr.Replace("get", "/", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "new") }) {
+		t.Error("Replace: got false")
+	}
+	if w := serve(rr, http.MethodGet, "/"); w.Body.String() != "new" {
+		t.Errorf("GET / after Replace: got %q, want new", w.Body)
+	}
+	if rr.Replace(http.MethodPost, "/", okHandler) {
+		t.Error("Replace of a missing route: got true")
+	}
+}
+
+func TestMiddlewarePanic(t *testing.T) {
+	rr := New()
+	rr.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/panic" {
+				panic("middleware failed")
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	rr.Get("/panic", okHandler, false)
+	rr.Get("/", okHandler, false)
+
+	if w := serve(rr, http.MethodGet, "/panic"); w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "middleware failed") {
+		t.Errorf("GET /panic: got %d %q, want 500", w.Code, w.Body)
+	}
+
+	var recovered interface{}
+	var stack []byte
+	rr.Handlers.Recovery = func(err interface{}, trace []byte, w http.ResponseWriter, r *http.Request) {
+		recovered, stack = err, trace
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	if w := serve(rr, http.MethodGet, "/panic"); w.Code != http.StatusInternalServerError {
+		t.Errorf("GET /panic with Recovery: got %d, want 500", w.Code)
+	}
+	if recovered != "middleware failed" || len(stack) == 0 {
+		t.Errorf("Recovery: got %v with a %d byte stack", recovered, len(stack))
+	}
+
+	if w := serve(rr, http.MethodGet, "/"); w.Code != http.StatusOK {
+		t.Errorf("GET /: got %d, want 200", w.Code)
+	}
+}
+
+func TestCustomMethods(t *testing.T) {
+	rr := New()
+	rr.Add("propfind", "/dav/(?P<file>.+)", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMultiStatus)
+		io.WriteString(w, rr.Params(r).Get("file"))
+	}, false)
+	rr.Add("MKCOL", "/dav/(?P<file>.+)", okHandler, false)
+
+	if routes := rr.Routes(); len(routes) != 2 || routes[0].Method != "PROPFIND" {
+		t.Fatalf("Routes: got %+v, want a PROPFIND route first", routes)
+	}
+	if w := serve(rr, "PROPFIND", "/dav/a.txt"); w.Code != http.StatusMultiStatus || w.Body.String() != "a.txt" {
+		t.Errorf("PROPFIND /dav/a.txt: got %d %q", w.Code, w.Body)
+	}
+	if w := serve(rr, "MKCOL", "/dav/dir"); w.Code != http.StatusOK {
+		t.Errorf("MKCOL /dav/dir: got %d, want 200", w.Code)
+	}
+
+	// Other methods get a 405 listing the custom ones
+	w := serve(rr, http.MethodGet, "/dav/a.txt")
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "MKCOL, PROPFIND" {
+		t.Errorf("GET /dav/a.txt: got %d, Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+	if w := serve(rr, http.MethodOptions, "/dav/a.txt"); w.Header().Get("Allow") != "MKCOL, OPTIONS, PROPFIND" {
+		t.Errorf("OPTIONS /dav/a.txt: got %d, Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+
+	if _, ok := rr.Lookup("propfind", "/dav/(?P<file>.+)"); !ok {
+		t.Error("Lookup with a lowercase method: got false")
+	}
+	if !rr.Remove("mkcol", "/dav/(?P<file>.+)") {
+		t.Error("Remove with a lowercase method: got false")
+	}
+	if w := serve(rr, "MKCOL", "/dav/dir"); w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "PROPFIND" {
+		t.Errorf("MKCOL /dav/dir after Remove: got %d, Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+}
+
+func TestMiddlewareContext(t *testing.T) {
+	type userKey struct{}
+	rr := New()
+	rr.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Params are available to middleware too
+			ctx := context.WithValue(r.Context(), userKey{}, "user-"+rr.Params(r).Get("id"))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	})
+
+	var user interface{}
+	var id string
+	rr.Get(`/users/(?P<id>\d+)`, func(w http.ResponseWriter, r *http.Request) {
+		user, id = r.Context().Value(userKey{}), rr.Params(r).Get("id")
+	}, false)
+
+	serve(rr, http.MethodGet, "/users/7")
+	if user != "user-7" || id != "7" {
+		t.Errorf("GET /users/7: got user %v, id %q", user, id)
+	}
+
+	// Values set before routing survive too
+	r := httptest.NewRequest(http.MethodGet, "/users/8", nil)
+	type outerKey struct{}
+	var outer interface{}
+	rr.Replace(http.MethodGet, `/users/(?P<id>\d+)`, func(w http.ResponseWriter, r *http.Request) {
+		outer, id = r.Context().Value(outerKey{}), rr.Params(r).Get("id")
+	})
+	rr.ServeHTTP(httptest.NewRecorder(), r.WithContext(context.WithValue(r.Context(), outerKey{}, "outer")))
+	if outer != "outer" || id != "8" {
+		t.Errorf("GET /users/8: got outer %v, id %q", outer, id)
+	}
+}
+
+func TestParamsPerRouter(t *testing.T) {
+	outer, inner := New(), New()
+	var innerID, outerID string
+	inner.Get("/x/(?P<id>.+)", func(w http.ResponseWriter, r *http.Request) {
+		innerID, outerID = inner.Params(r).Get("id"), outer.Params(r).Get("id")
+	}, false)
+	outer.Get("/(?P<id>[a-z])/.*", inner.ServeHTTP, false)
+
+	serve(outer, http.MethodGet, "/x/42")
+	if innerID != "42" || outerID != "x" {
+		t.Errorf("GET /x/42: got inner id %q, outer id %q, want 42 and x", innerID, outerID)
+	}
+}
+
+func TestAddE(t *testing.T) {
+	rr := New()
+	if err := rr.AddE(http.MethodGet, `/(?P<id>\d+)`, okHandler, false); err != nil {
+		t.Errorf("AddE with a valid pattern: got %v", err)
+	}
+	if w := serve(rr, http.MethodGet, "/5"); w.Code != http.StatusOK {
+		t.Errorf("GET /5: got %d, want 200", w.Code)
+	}
+
+	err := rr.AddE(http.MethodGet, "/(", okHandler, false)
+	if err == nil || !strings.Contains(err.Error(), `"/(": invalid pattern`) {
+		t.Errorf("AddE with an invalid pattern: got %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Add with an invalid pattern didn't panic")
+		}
+	}()
+	rr.Add(http.MethodGet, "[", okHandler, false)
+}
+
+func TestAddNilHandler(t *testing.T) {
+	rr := New()
+	if err := rr.AddE(http.MethodGet, "/a", nil, false); !errors.Is(err, ErrNilHandler) || err.Error() != `"/a": nil handler` {
+		t.Errorf("AddE with a nil handler: got %v", err)
+	}
+	if len(rr.Routes()) != 0 {
+		t.Errorf("routes after a nil handler: got %v", rr.Routes())
+	}
+
+	defer func() {
+		if err, _ := recover().(error); !errors.Is(err, ErrNilHandler) {
+			t.Errorf("Get with a nil handler: got panic %v, want ErrNilHandler", err)
+		}
+	}()
+	rr.Get("/a", nil, false)
+}
+
+func TestDuplicateRoute(t *testing.T) {
+	rr := New()
+	rr.Get("/a", okHandler, false)
+
+	if err := rr.AddE("get", "/a", okHandler, false); !errors.Is(err, ErrDuplicateRoute) || err.Error() != `"/a": GET: duplicate route` {
+		t.Errorf("AddE of a duplicate: got %v", err)
+	}
+	if err := rr.AddE(http.MethodPost, "/a", okHandler, false); err != nil {
+		t.Errorf("AddE with another method: got %v", err)
+	}
+
+	// A higher priority route would match first, so it isn't a duplicate
+	rr.AddWithPriority(http.MethodGet, "/a", okHandler, false, 1)
+	if err := rr.AddE(http.MethodGet, "/a", okHandler, false); !errors.Is(err, ErrDuplicateRoute) {
+		t.Errorf("AddE below a higher priority route: got %v", err)
+	}
+
+	// Prefix and host routes are distinct from path routes
+	rr.AddPrefix(http.MethodGet, "/a", okHandler, false)
+	rr.AddHost("x.com", http.MethodGet, "/a", okHandler, false)
+
+	defer func() {
+		if err, _ := recover().(error); !errors.Is(err, ErrDuplicateRoute) {
+			t.Errorf("AddMethods overlapping a route: got panic %v, want ErrDuplicateRoute", err)
+		}
+	}()
+	rr.AddMethods([]string{http.MethodPut, http.MethodPost}, "/a", okHandler, false)
+}
+
+func TestParamsWithoutRoute(t *testing.T) {
+	rr := New()
+	params := rr.Params(httptest.NewRequest(http.MethodGet, "/?q=1", nil))
+	if len(params.Values) != 0 || params.Get("id") != "" || params.Query("q") != "1" {
+		t.Errorf("Params of a bare request: got %v, query q %q", params.Values, params.Query("q"))
+	}
+
+	// The empty params can still be written to
+	params.Set("a", "b")
+	if params.Get("a") != "b" {
+		t.Errorf("Params of a bare request after Set: got %v", params.Values)
+	}
+}
+
+func TestRoute(t *testing.T) {
+	rr := New()
+	var got RouteInfo
+	var found bool
+	rr.AddNamed("user", http.MethodGet, `/u/(?P<id>\d+)`, func(w http.ResponseWriter, r *http.Request) {
+		got, found = rr.Route(r)
+	}, false)
+
+	serve(rr, http.MethodGet, "/u/3")
+	if !found || got.Pattern != `/u/(?P<id>\d+)` || got.Name != "user" || got.Method != http.MethodGet {
+		t.Errorf("Route in the handler: got %+v, %v", got, found)
+	}
+	if _, ok := rr.Route(httptest.NewRequest(http.MethodGet, "/", nil)); ok {
+		t.Error("Route of a bare request: got true")
+	}
+}
+
+// trace returns middleware appending its name to the order before running the
+// next handler
+func trace(order *[]string, name string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*order = append(*order, name)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	rr := New()
+	var order []string
+	rr.Use(trace(&order, "a"))
+	rr.Use(trace(&order, "b"), trace(&order, "c"))
+	rr.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			order = append(order, "id "+rr.Params(r).Get("id"))
+			next.ServeHTTP(w, r)
+		})
+	})
+	rr.Get(`/(?P<id>\d)`, func(w http.ResponseWriter, r *http.Request) { order = append(order, "handler") }, false)
+
+	serve(rr, http.MethodGet, "/7")
+	if got := strings.Join(order, ", "); got != "a, b, c, id 7, handler" {
+		t.Errorf("GET /7: got order %q", got)
+	}
+
+	// Middleware only runs once a route matched
+	order = nil
+	if serve(rr, http.MethodGet, "/missing"); order != nil {
+		t.Errorf("GET /missing: got order %q, want none", order)
+	}
+}
+
+func TestRouteMiddleware(t *testing.T) {
+	rr := New()
+	var order []string
+	rr.Use(trace(&order, "router"))
+	rr.AddWith(http.MethodGet, "/a", okHandler, false, trace(&order, "a1"), trace(&order, "a2"))
+	rr.Get("/b", okHandler, false)
+
+	serve(rr, http.MethodGet, "/a")
+	if got := strings.Join(order, ", "); got != "router, a1, a2" {
+		t.Errorf("GET /a: got order %q", got)
+	}
+
+	order = nil
+	serve(rr, http.MethodGet, "/b")
+	if got := strings.Join(order, ", "); got != "router" {
+		t.Errorf("GET /b: got order %q", got)
+	}
+}
+
+func TestServer(t *testing.T) {
+	rr := New()
+	rr.Get("/hi", okHandler, false)
+	server := httptest.NewServer(rr)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil || res.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Errorf("GET /hi: got %d %q, %v", res.StatusCode, body, err)
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+	rr := New()
+	rr.Get("/panic", func(w http.ResponseWriter, r *http.Request) { panic("boom") }, false)
+
+	if w := serve(rr, http.MethodGet, "/panic"); w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "boom") {
+		t.Errorf("GET /panic: got %d %q, want 500", w.Code, w.Body)
+	}
+
+	// Without a 500 handler the plain error response is used
+	delete(rr.Handlers.ErrorCodes, http.StatusInternalServerError)
+	if w := serve(rr, http.MethodGet, "/panic"); w.Code != http.StatusInternalServerError {
+		t.Errorf("GET /panic without a 500 handler: got %d, want 500", w.Code)
+	}
+
+	var recovered interface{}
+	rr.Handlers.Recovery = func(err interface{}, stack []byte, w http.ResponseWriter, r *http.Request) {
+		recovered = err
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if w := serve(rr, http.MethodGet, "/panic"); w.Code != http.StatusServiceUnavailable || recovered != "boom" {
+		t.Errorf("GET /panic with Recovery: got %d, recovered %v", w.Code, recovered)
+	}
+}
+
+func TestMissingErrorHandlers(t *testing.T) {
+	newRouter := func(code int) *RegRouter {
+		rr := New()
+		rr.Put("/put", okHandler, false)
+		rr.Get("/panic", func(w http.ResponseWriter, r *http.Request) { panic("boom") }, false)
+		delete(rr.Handlers.ErrorCodes, code)
+		return rr
+	}
+
+	if w := serve(newRouter(http.StatusNotFound), http.MethodGet, "/missing"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /missing without a 404 handler: got %d, want 404", w.Code)
+	}
+	if w := serve(newRouter(http.StatusMethodNotAllowed), http.MethodGet, "/put"); w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "PUT" {
+		t.Errorf("GET /put without a 405 handler: got %d, Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+	if w := serve(newRouter(http.StatusInternalServerError), http.MethodGet, "/panic"); w.Code != http.StatusInternalServerError {
+		t.Errorf("GET /panic without a 500 handler: got %d, want 500", w.Code)
+	}
+
+	// A RegRouter without any handlers falls back too
+	rr := newRouter(0)
+	rr.Handlers = Handlers{}
+	if w := serve(rr, http.MethodGet, "/missing"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /missing without handlers: got %d, want 404", w.Code)
+	}
+}
+
+func TestConcurrentAdd(t *testing.T) {
+	rr := New()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				rr.Get(fmt.Sprintf("/r%d/%d", i, j), okHandler, false)
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				serve(rr, http.MethodGet, fmt.Sprintf("/r%d/%d", i, j))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if got := len(rr.Routes()); got != 400 {
+		t.Errorf("routes: got %d, want 400", got)
+	}
+	if w := serve(rr, http.MethodGet, "/r7/49"); w.Code != http.StatusOK {
+		t.Errorf("GET /r7/49: got %d, want 200", w.Code)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	rr := New()
+	rr.Get("/a", okHandler, false)
+	rr.Get("/b", okHandler, false)
+
+	if rr.Remove(http.MethodPost, "/a") {
+		t.Error("Remove of a route with another method: got true")
+	}
+	if !rr.Remove("get", "/a") {
+		t.Error("Remove: got false")
+	}
+	if rr.Remove(http.MethodGet, "/a") {
+		t.Error("Remove of a removed route: got true")
+	}
+
+	if w := serve(rr, http.MethodGet, "/a"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /a after Remove: got %d, want 404", w.Code)
+	}
+	if w := serve(rr, http.MethodGet, "/b"); w.Code != http.StatusOK {
+		t.Errorf("GET /b after Remove: got %d, want 200", w.Code)
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	rr := New()
+	rr.Get("/a", okHandler, false)
+	rr.Group("/g").Post("/b", okHandler, true)
+	rr.AddNamed("named", http.MethodPut, "/c", okHandler, false)
+
+	want := []RouteInfo{
+		{Method: http.MethodGet, Pattern: "/a"},
+		{Method: http.MethodPost, Pattern: "/g/b", CORS: true},
+		{Name: "named", Method: http.MethodPut, Pattern: "/c"},
+	}
+	if got := rr.Routes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Routes: got %+v, want %+v", got, want)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	rr := New()
+	var got string
+	record := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) { got = name }
+	}
+	rr.Get("/.*", record("catch-all"), false)
+	rr.AddWithPriority(http.MethodGet, "/x.*", record("x"), false, 1)
+	rr.AddWithPriority(http.MethodGet, "/xy", record("xy"), false, 1)
+	rr.AddWithPriority(http.MethodGet, "/xy", record("xy high"), false, 10)
+
+	// A specific route beats the catch-all added before it, and routes of
+	// equal priority match in the order they were added
+	for path, want := range map[string]string{"/xz": "x", "/xy": "xy high", "/q": "catch-all"} {
+		if serve(rr, http.MethodGet, path); got != want {
+			t.Errorf("GET %s: got %q, want %q", path, got, want)
+		}
+	}
+
+	var patterns []string
+	for _, route := range rr.Routes() {
+		patterns = append(patterns, route.Pattern)
+	}
+	if strings.Join(patterns, " ") != "/xy /x.* /xy /.*" {
+		t.Errorf("Routes: got patterns %q", patterns)
+	}
+}
+
+func TestStrictSlash(t *testing.T) {
+	rr := New()
+	rr.Get("/users", okHandler, false)
+	rr.Post("/dir/", okHandler, false)
+
+	// Strict by default
+	if w := serve(rr, http.MethodGet, "/users/"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /users/ when strict: got %d, want 404", w.Code)
+	}
+
+	rr.StrictSlash(false)
+	if w := serve(rr, http.MethodGet, "/users/?a=1"); w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/users?a=1" {
+		t.Errorf("GET /users/?a=1: got %d, Location %q", w.Code, w.Header().Get("Location"))
+	}
+
+	// Methods other than GET and HEAD keep their method and body
+	if w := serve(rr, http.MethodPost, "/dir"); w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != "/dir/" {
+		t.Errorf("POST /dir: got %d, Location %q", w.Code, w.Header().Get("Location"))
+	}
+	if w := serve(rr, http.MethodGet, "/users"); w.Code != http.StatusOK {
+		t.Errorf("GET /users: got %d, want 200", w.Code)
+	}
+	if w := serve(rr, http.MethodGet, "/"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /: got %d, want 404", w.Code)
+	}
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	rr := New()
+	var name string
+	rr.Get("/users/(?P<name>[a-z]+)", func(w http.ResponseWriter, r *http.Request) {
+		name = rr.Params(r).Get("name")
+	}, false)
+
+	if w := serve(rr, http.MethodGet, "/Users/Bob"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /Users/Bob when case-sensitive: got %d, want 404", w.Code)
+	}
+
+	// Existing and new routes ignore the case, captures keeping theirs
+	rr.CaseInsensitive(true)
+	rr.Get("/Other", okHandler, false)
+	if w := serve(rr, http.MethodGet, "/Users/Bob"); w.Code != http.StatusOK || name != "Bob" {
+		t.Errorf("GET /Users/Bob: got %d, name %q", w.Code, name)
+	}
+	if w := serve(rr, http.MethodGet, "/oTHER"); w.Code != http.StatusOK {
+		t.Errorf("GET /oTHER: got %d, want 200", w.Code)
+	}
+
+	rr.CaseInsensitive(false)
+	if w := serve(rr, http.MethodGet, "/other"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /other when case-sensitive again: got %d, want 404", w.Code)
+	}
+}
+
+// serveHost runs a request for the host through the handler
+func serveHost(h http.Handler, method string, host string, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, path, nil)
+	r.Host = host
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestAddHost(t *testing.T) {
+	rr := New()
+	var got string
+	rr.AddHost(`example\.com`, http.MethodGet, "/", func(w http.ResponseWriter, r *http.Request) { got = "exact" }, false)
+	rr.AddHost(`[a-z]+\.example\.com`, http.MethodGet, "/", func(w http.ResponseWriter, r *http.Request) { got = "wildcard" }, false)
+
+	for host, want := range map[string]string{"example.com:8080": "exact", "Foo.example.com": "wildcard", "foo.bar.example.com": "", "other.com": ""} {
+		got = ""
+		w := serveHost(rr, http.MethodGet, host, "/")
+		if got != want || want == "" && w.Code != http.StatusNotFound {
+			t.Errorf("GET / on %s: got %d %q, want %q", host, w.Code, got, want)
+		}
+	}
+
+	if routes := rr.Routes(); routes[1].Host != `[a-z]+\.example\.com` {
+		t.Errorf("Routes: got host %q", routes[1].Host)
+	}
+	if err := rr.AddAll([]RouteSpec{{Method: http.MethodGet, Pattern: "/", Handler: okHandler, Host: "("}}); err == nil {
+		t.Error("invalid host pattern: got nil error")
+	}
+}
+
+func TestHostParams(t *testing.T) {
+	rr := New()
+	var p Params
+	rr.AddHost(`(?P<tenant>[a-z]+)\.example\.com`, http.MethodGet, `/(?P<id>\d+)`, func(w http.ResponseWriter, r *http.Request) { p = rr.Params(r) }, false)
+
+	serveHost(rr, http.MethodGet, "acme.example.com", "/5")
+	if p.Get("tenant") != "acme" || p.Get("id") != "5" {
+		t.Errorf("GET /5 on acme.example.com: got %v", p.Values)
+	}
+}
+
+func TestParamsContextKey(t *testing.T) {
+	var outer, inner RegRouter
+	var got string
+	inner.Get("/x/(?P<id>.+)", func(w http.ResponseWriter, r *http.Request) {
+		got = outer.Params(r).Get("id") + " " + inner.Params(r).Get("id")
+	}, false)
+	outer.Get("/(?P<id>[a-z])/.*", inner.ServeHTTP, false)
+
+	// Zero value routers get keys of their own too
+	serve(&outer, http.MethodGet, "/x/42")
+	if got != "x 42" {
+		t.Errorf("GET /x/42: got %q, want %q", got, "x 42")
+	}
+
+	// Params stored under another key aren't taken for the router's
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), struct{}{}, Params{Values: map[string]string{"id": "other"}}))
+	if id := outer.Params(r).Get("id"); id != "" {
+		t.Errorf("Params under another key: got id %q", id)
+	}
+}
+
+func TestAddPrefix(t *testing.T) {
+	rr := New()
+	var bucket, prefix string
+	rr.AddPrefix(http.MethodGet, "/files/(?P<bucket>[a-z]+)", func(w http.ResponseWriter, r *http.Request) {
+		bucket, prefix = rr.Params(r).Get("bucket"), rr.Params(r).Get("0")
+	}, false)
+	rr.Get("/exact", okHandler, false)
+
+	if serve(rr, http.MethodGet, "/files/abc/deep/x"); bucket != "abc" || prefix != "/files/abc" {
+		t.Errorf("GET /files/abc/deep/x: got bucket %q, prefix %q", bucket, prefix)
+	}
+	if !rr.Routes()[0].Prefix {
+		t.Error("Routes: prefix route isn't described as one")
+	}
+
+	// Anchored routes still match the whole path
+	if w := serve(rr, http.MethodGet, "/exact"); w.Code != http.StatusOK {
+		t.Errorf("GET /exact: got %d, want 200", w.Code)
+	}
+	if w := serve(rr, http.MethodGet, "/exact/more"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /exact/more: got %d, want 404", w.Code)
+	}
+}
+
+func TestLogHook(t *testing.T) {
+	rr := New()
+	var status int
+	var duration time.Duration
+	rr.Handlers.Log = func(r *http.Request, code int, d time.Duration) { status, duration = code, d }
+	rr.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond)
+		w.WriteHeader(http.StatusAccepted)
+	}, false)
+	rr.Get("/panic", func(w http.ResponseWriter, r *http.Request) { panic("boom") }, false)
+	rr.Get("/empty", func(w http.ResponseWriter, r *http.Request) {}, false)
+
+	serve(rr, http.MethodGet, "/slow")
+	if status != http.StatusAccepted || duration < time.Millisecond {
+		t.Errorf("GET /slow: got status %d, duration %v", status, duration)
+	}
+
+	for path, want := range map[string]int{"/panic": http.StatusInternalServerError, "/empty": http.StatusOK, "/missing": http.StatusNotFound} {
+		status, duration = 0, 0
+		if serve(rr, http.MethodGet, path); status != want || duration <= 0 {
+			t.Errorf("GET %s: got status %d, duration %v, want %d", path, status, duration, want)
+		}
+	}
+}
+
+func TestMetricsHook(t *testing.T) {
+	rr := New()
+	var pattern, method string
+	var status int
+	rr.Handlers.Metrics = func(p string, m string, code int, d time.Duration) { pattern, method, status = p, m, code }
+	rr.Get(`/u/(?P<id>\d+)`, okHandler, false)
+	rr.Get("/panic", func(w http.ResponseWriter, r *http.Request) { panic("boom") }, false)
+
+	tests := []struct {
+		method, path, pattern string
+		status                int
+	}{
+		{http.MethodGet, "/u/1", `/u/(?P<id>\d+)`, http.StatusOK},
+		{http.MethodHead, "/u/1", `/u/(?P<id>\d+)`, http.StatusOK},
+		{http.MethodGet, "/missing", "", http.StatusNotFound},
+		{http.MethodGet, "/panic", "/panic", http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		serve(rr, test.method, test.path)
+		if pattern != test.pattern || method != test.method || status != test.status {
+			t.Errorf("%s %s: got %q %s %d, want %q %d", test.method, test.path, pattern, method, status, test.pattern, test.status)
+		}
+	}
+}
+
+func TestConstraints(t *testing.T) {
+	rr := New()
+	var got string
+	rr.AddWithConstraints(http.MethodGet, `/items/(?P<id>[^/]+)/(\w+)`, func(w http.ResponseWriter, r *http.Request) { got = "number" }, false,
+		map[string]*regexp.Regexp{"id": regexp.MustCompile(`\d+`), "2": regexp.MustCompile("a|ab")})
+	rr.Get("/items/(?P<slug>[^/]+)/ab", func(w http.ResponseWriter, r *http.Request) { got = "slug" }, false)
+
+	// A rejected capture falls through to the next route, or a 404
+	for path, want := range map[string]string{"/items/12/ab": "number", "/items/12/a": "number", "/items/x12/ab": "slug"} {
+		if serve(rr, http.MethodGet, path); got != want {
+			t.Errorf("GET %s: got %q, want %q", path, got, want)
+		}
+	}
+	for _, path := range []string{"/items/12/abc", "/items/x1/b"} {
+		if w := serve(rr, http.MethodGet, path); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s: got %d, want 404", path, w.Code)
+		}
+	}
+}
+
+func TestAddMethods(t *testing.T) {
+	rr := New()
+	rr.SetCORSDefaults(CORSDefaults{AllowOrigins: []string{"*"}})
+	rr.AddMethods([]string{"get", http.MethodPost}, "/m", okHandler, true)
+
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodHead} {
+		if w := serve(rr, method, "/m"); w.Code != http.StatusOK {
+			t.Errorf("%s /m: got %d, want 200", method, w.Code)
+		}
+	}
+	if w := serve(rr, http.MethodDelete, "/m"); w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, POST" {
+		t.Errorf("DELETE /m: got %d, Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+
+	// The route is described by its joined methods
+	r := httptest.NewRequest(http.MethodGet, "/m", nil)
+	r.Header.Set("Origin", "https://a.example")
+	w := httptest.NewRecorder()
+	rr.ServeHTTP(w, r)
+	if got := w.Header().Get("Access-Control-Allow-Methods"); got != "GET, POST" {
+		t.Errorf("GET /m CORS methods: got %q, want %q", got, "GET, POST")
+	}
+	if routes := rr.Routes(); routes[0].Method != "GET, POST" {
+		t.Errorf("Routes: got method %q", routes[0].Method)
+	}
+	if !rr.Remove("GET, POST", "/m") {
+		t.Error("Remove by the joined methods: got false")
+	}
+}
+
+func TestAllowHeader(t *testing.T) {
+	rr := New()
+	rr.Put("/a/.*", okHandler, false)
+	rr.Delete("/a/b", okHandler, false)
+	rr.Post("/a/(b)", okHandler, false)
+	rr.Put("/a/b", okHandler, false)
+	rr.AddMethods([]string{http.MethodPatch, http.MethodDelete}, "/a/(?:b)", okHandler, false)
+
+	if w := serve(rr, http.MethodGet, "/a/b"); w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "DELETE, PATCH, POST, PUT" {
+		t.Errorf("GET /a/b: got %d, Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+}
+
+func TestOptions(t *testing.T) {
+	rr := New()
+	rr.SetCORSDefaults(CORSDefaults{AllowOrigins: []string{"*"}})
+	rr.Get("/n", okHandler, false)
+	rr.Put("/n", okHandler, false)
+	rr.Post("/c", okHandler, true)
+
+	tests := []struct {
+		path   string
+		code   int
+		allow  string
+		origin string
+	}{
+		{"/n", http.StatusNoContent, "GET, OPTIONS, PUT", ""},
+		{"/c", http.StatusNoContent, "OPTIONS, POST", "https://a.example"},
+		{"/x", http.StatusNotFound, "", ""},
+	}
+	for _, test := range tests {
+		w := serveOrigin(rr, http.MethodOptions, test.path, "https://a.example", false)
+		if w.Code != test.code || w.Header().Get("Allow") != test.allow || w.Header().Get("Access-Control-Allow-Origin") != test.origin {
+			t.Errorf("OPTIONS %s: got %d %v, want %d Allow %q", test.path, w.Code, w.Header(), test.code, test.allow)
+		}
+	}
+}
+
+func TestCustomErrorCode(t *testing.T) {
+	rr := New()
+	rr.Handlers.ErrorCodes[http.StatusUnauthorized] = func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", "Basic")
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	rr.Get("/private", func(w http.ResponseWriter, r *http.Request) { rr.Error(http.StatusUnauthorized, nil, w, r) }, false)
+	rr.Get("/forbidden", func(w http.ResponseWriter, r *http.Request) { rr.Error(http.StatusForbidden, nil, w, r) }, false)
+
+	if w := serve(rr, http.MethodGet, "/private"); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != "Basic" {
+		t.Errorf("GET /private: got %d %v", w.Code, w.Header())
+	}
+
+	// Codes without a handler get a plain error response
+	if w := serve(rr, http.MethodGet, "/forbidden"); w.Code != http.StatusForbidden || w.Body.String() != "403 - Forbidden\n\n" {
+		t.Errorf("GET /forbidden: got %d %q", w.Code, w.Body)
+	}
+}
+
+func TestNotFoundHandler(t *testing.T) {
+	rr := New()
+	rr.Get("/a", okHandler, false)
+	rr.NotFound(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "index") }))
+
+	if w := serve(rr, http.MethodGet, "/x/y"); w.Code != http.StatusOK || w.Body.String() != "index" {
+		t.Errorf("GET /x/y: got %d %q, want 200 index", w.Code, w.Body)
+	}
+	if w := serve(rr, http.MethodPost, "/a"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /a: got %d, want 405", w.Code)
+	}
+}
+
+func TestMethodNotAllowedHandler(t *testing.T) {
+	rr := New()
+	rr.Put("/a", okHandler, false)
+	rr.Get("/a", okHandler, false)
+	var got []string
+	rr.MethodNotAllowed(func(allowed []string, w http.ResponseWriter, r *http.Request) {
+		got = allowed
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	})
+
+	if w := serve(rr, http.MethodPost, "/a"); w.Code != http.StatusMethodNotAllowed || !reflect.DeepEqual(got, []string{http.MethodGet, http.MethodPut}) {
+		t.Errorf("POST /a: got %d, allowed %q", w.Code, got)
+	}
+}
+
+// headerCounter records the status codes written to it
+type headerCounter struct {
+	*httptest.ResponseRecorder
+	codes []int
+}
+
+func (w *headerCounter) WriteHeader(code int) {
+	w.codes = append(w.codes, code)
+	w.ResponseRecorder.WriteHeader(code)
+}
+
+func TestPanicAfterWrite(t *testing.T) {
+	rr := New()
+	rr.Get("/partial", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		io.WriteString(w, "partial")
+		w.(http.Flusher).Flush()
+		panic("midway")
+	}, false)
+
+	// The started response is aborted instead of getting a 500 written over it
+	w := &headerCounter{ResponseRecorder: httptest.NewRecorder()}
+	func() {
+		defer func() {
+			if err := recover(); err != http.ErrAbortHandler {
+				t.Errorf("GET /partial: got panic %v, want http.ErrAbortHandler", err)
+			}
+		}()
+		rr.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/partial", nil))
+	}()
+	if !reflect.DeepEqual(w.codes, []int{http.StatusAccepted}) || w.Body.String() != "partial" {
+		t.Errorf("GET /partial: got codes %v, body %q", w.codes, w.Body)
+	}
+
+	// The client sees the response cut short
+	server := httptest.NewServer(rr)
+	defer server.Close()
+	res, err := http.Get(server.URL + "/partial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if body, err := io.ReadAll(res.Body); res.StatusCode != http.StatusAccepted || err == nil || strings.Contains(string(body), "500") {
+		t.Errorf("GET /partial from a server: got %d %q, %v", res.StatusCode, body, err)
+	}
+}
+
+func TestMatchRawPath(t *testing.T) {
+	rr := New()
+	var got Params
+	rr.Get("/k/(?P<key>[^/]+)", func(w http.ResponseWriter, r *http.Request) {
+		got = rr.Params(r)
+	}, false)
+	rr.Get("/s/", okHandler, false)
+
+	sub := New()
+	sub.MatchRawPath(true)
+	var mounted Params
+	sub.Get("/f/(?P<key>[^/]+)", func(w http.ResponseWriter, r *http.Request) {
+		mounted = sub.Params(r)
+	}, false)
+	rr.Mount("/m", sub)
+
+	// The decoded path splits the encoded slash
+	if w := serve(rr, http.MethodGet, "/k/a%2Fb"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /k/a%%2Fb: got %d, want 404", w.Code)
+	}
+
+	rr.MatchRawPath(true)
+	if w := serve(rr, http.MethodGet, "/k/a%2Fb%20c"); w.Code != http.StatusOK || got.Get("key") != "a/b c" {
+		t.Errorf("GET /k/a%%2Fb%%20c matching the raw path: got %d %q, want 200 \"a/b c\"", w.Code, got.Get("key"))
+	}
+	if w := serve(rr, http.MethodGet, "/m/f/a%2Fb"); w.Code != http.StatusOK || mounted.Get("key") != "a/b" {
+		t.Errorf("GET /m/f/a%%2Fb: got %d %q, want 200 \"a/b\"", w.Code, mounted.Get("key"))
+	}
+
+	// Slash redirects keep the query
+	rr.StrictSlash(false)
+	if w := serve(rr, http.MethodGet, "/s?x=1"); w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/s/?x=1" {
+		t.Errorf("GET /s?x=1: got %d %q, want 301 \"/s/?x=1\"", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestLookup(t *testing.T) {
+	rr := New()
+	rr.Get(`/u/(?P<id>\d+)`, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, rr.Params(r).Get("id"))
+	}, false)
+
+	handler, ok := rr.Lookup("get", `/u/(?P<id>\d+)`)
+	if !ok {
+		t.Fatal("Lookup of a registered route: got false")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), rr.key(), Params{Values: map[string]string{"id": "9"}}))
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if w.Body.String() != "9" {
+		t.Errorf("looked up handler: got %q, want 9", w.Body)
+	}
+
+	if _, ok := rr.Lookup(http.MethodPost, `/u/(?P<id>\d+)`); ok {
+		t.Error("Lookup with another method: got true")
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	rr := New()
+	var seen string
+	rr.Get(`/users/(?P<id>\d+)`, func(w http.ResponseWriter, r *http.Request) {
+		seen = r.URL.Path
+	}, false)
+	rr.Get("/dir/", okHandler, false)
+
+	// Paths are matched as they are by default
+	if w := serve(rr, http.MethodGet, "//users///1"); w.Code != http.StatusNotFound {
+		t.Errorf("GET //users///1: got %d, want 404", w.Code)
+	}
+
+	rr.CleanPath(true, false)
+	for _, path := range []string{"//users///1", "/users/./1", "/a/../users/1", "/users/1"} {
+		seen = ""
+		if w := serve(rr, http.MethodGet, path); w.Code != http.StatusOK || seen != "/users/1" {
+			t.Errorf("GET %s: got %d %q, want 200 /users/1", path, w.Code, seen)
+		}
+	}
+
+	// The trailing slash is kept
+	if w := serve(rr, http.MethodGet, "//dir//"); w.Code != http.StatusOK {
+		t.Errorf("GET //dir//: got %d, want 200", w.Code)
+	}
+
+	rr.CleanPath(true, true)
+	tests := []struct {
+		method, path string
+		code         int
+		location     string
+	}{
+		{http.MethodGet, "//users///1?x=1", http.StatusMovedPermanently, "/users/1?x=1"},
+		{http.MethodPost, "/x/../dir/", http.StatusPermanentRedirect, "/dir/"},
+	}
+	for _, test := range tests {
+		w := serve(rr, test.method, test.path)
+		if w.Code != test.code || w.Header().Get("Location") != test.location {
+			t.Errorf("%s %s: got %d %q, want %d %q", test.method, test.path, w.Code, w.Header().Get("Location"), test.code, test.location)
+		}
+	}
+}
+
+func TestPanicStack(t *testing.T) {
+	rr := New()
+	var stack []byte
+	fallback := rr.Handlers.ErrorCodes[http.StatusInternalServerError]
+	rr.Handlers.ErrorCodes[http.StatusInternalServerError] = func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+		stack, _ = data["stack"].([]byte)
+		fallback(data, w, r)
+	}
+	rr.Get("/panic", func(w http.ResponseWriter, r *http.Request) { panic("boom") }, false)
+
+	w := serve(rr, http.MethodGet, "/panic")
+	if w.Code != http.StatusInternalServerError || !strings.Contains(string(stack), "TestPanicStack") {
+		t.Errorf("GET /panic: got %d, stack %q", w.Code, stack)
+	}
+
+	// The default handler doesn't send the stack
+	if strings.Contains(w.Body.String(), "goroutine") {
+		t.Errorf("GET /panic: got the stack in %q", w.Body)
+	}
+}
+
+func TestAddSecure(t *testing.T) {
+	rr := New()
+	rr.AddSecure(http.MethodGet, "/s", okHandler, false)
+	rr.Get("/t/", okHandler, false)
+	rr.AddSecure(http.MethodGet, "/t", okHandler, false)
+	rr.StrictSlash(false)
+
+	tests := []struct {
+		path  string
+		tls   bool
+		proto string
+		code  int
+	}{
+		{"/s", true, "", http.StatusOK},
+		{"/s", false, "https", http.StatusOK},
+		{"/s", false, "HTTPS, http", http.StatusOK},
+		{"/s", false, "", http.StatusNotFound},
+		{"/s", false, "http", http.StatusNotFound},
+		// An insecure request falls through to the slash redirect
+		{"/t", false, "", http.StatusMovedPermanently},
+		{"/t", true, "", http.StatusOK},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, test.path, nil)
+		if test.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+		if test.proto != "" {
+			r.Header.Set("X-Forwarded-Proto", test.proto)
+		}
+
+		w := httptest.NewRecorder()
+		rr.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("GET %s, TLS %t, forwarded %q: got %d, want %d", test.path, test.tls, test.proto, w.Code, test.code)
+		}
+	}
+
+	if !rr.Routes()[0].Secure {
+		t.Errorf("RouteInfo of a secure route: got %+v", rr.Routes()[0])
+	}
+}
+
+func TestCatchAll(t *testing.T) {
+	rr := New()
+	var got Params
+	rr.AddNamed("files", http.MethodGet, "/files/*path", func(w http.ResponseWriter, r *http.Request) {
+		got = rr.Params(r)
+	}, false)
+
+	if w := serve(rr, http.MethodGet, "/files/a/b/c/d.txt"); w.Code != http.StatusOK || got.Get("path") != "a/b/c/d.txt" {
+		t.Errorf("GET /files/a/b/c/d.txt: got %d %q, want 200 a/b/c/d.txt", w.Code, got.Get("path"))
+	}
+	if w := serve(rr, http.MethodGet, "/filesx"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /filesx: got %d, want 404", w.Code)
+	}
+	if url, err := rr.URL("files", map[string]string{"path": "x/y"}); err != nil || url != "/files/x/y" {
+		t.Errorf(`URL("files"): got %q, %v, want "/files/x/y"`, url, err)
+	}
+}
+
+func TestFallback(t *testing.T) {
+	rr := New()
+	rr.Get("/a", okHandler, false)
+	hits := 0
+	rr.Fallback(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	if w := serve(rr, http.MethodGet, "/a"); w.Code != http.StatusOK || hits != 0 {
+		t.Errorf("GET /a: got %d, fallback hits %d", w.Code, hits)
+	}
+
+	// Misses reach the fallback, but method mismatches are still answered
+	if w := serve(rr, http.MethodGet, "/b"); w.Code != http.StatusTeapot || hits != 1 {
+		t.Errorf("GET /b: got %d, fallback hits %d", w.Code, hits)
+	}
+	if w := serve(rr, http.MethodPost, "/a"); w.Code != http.StatusMethodNotAllowed || hits != 1 {
+		t.Errorf("POST /a: got %d, fallback hits %d", w.Code, hits)
+	}
+	if w := serve(rr, http.MethodOptions, "/a"); w.Code != http.StatusNoContent || hits != 1 {
+		t.Errorf("OPTIONS /a: got %d, fallback hits %d", w.Code, hits)
+	}
+	rr.StrictSlash(false)
+	if w := serve(rr, http.MethodGet, "/a/"); w.Code != http.StatusMovedPermanently || hits != 1 {
+		t.Errorf("GET /a/: got %d, fallback hits %d", w.Code, hits)
+	}
+}
+
+func TestAddDeprecated(t *testing.T) {
+	rr := New()
+	rr.AddDeprecated(http.MethodGet, "/a", okHandler, false, time.Date(2027, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600)))
+	rr.AddDeprecated(http.MethodGet, "/b", okHandler, false, time.Time{})
+
+	// The sunset is sent in GMT, and left out when zero
+	tests := map[string]string{"/a": "Sat, 02 Jan 2027 02:04:05 GMT", "/b": ""}
+	for path, sunset := range tests {
+		w := serve(rr, http.MethodGet, path)
+		if w.Code != http.StatusOK || w.Header().Get("Deprecation") != "true" || w.Header().Get("Sunset") != sunset {
+			t.Errorf("GET %s: got %d %v, want Sunset %q", path, w.Code, w.Header(), sunset)
+		}
+	}
+}
+
+func TestAddPreload(t *testing.T) {
+	rr := New()
+	rr.AddPreload(http.MethodGet, "/", okHandler, false, "/style.css", "/app.js")
+
+	w := serve(rr, http.MethodGet, "/")
+	if want := []string{"</style.css>; rel=preload", "</app.js>; rel=preload"}; !reflect.DeepEqual(w.Header()["Link"], want) {
+		t.Errorf("GET /: got Link %q, want %q", w.Header()["Link"], want)
+	}
+}
+
+func TestDefaultHeaders(t *testing.T) {
+	rr := New()
+	rr.SetDefaultHeaders(map[string]string{"x-content-type-options": "nosniff", "X-Frame-Options": "DENY"})
+	rr.Get("/a", okHandler, false)
+	rr.Get("/b", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+	}, false)
+
+	// Errors get the headers too, and handlers can override them
+	tests := []struct {
+		path, nosniff, frame string
+	}{
+		{"/a", "nosniff", "DENY"},
+		{"/b", "nosniff", "SAMEORIGIN"},
+		{"/missing", "nosniff", "DENY"},
+	}
+	for _, test := range tests {
+		w := serve(rr, http.MethodGet, test.path)
+		if w.Header().Get("X-Content-Type-Options") != test.nosniff || w.Header().Get("X-Frame-Options") != test.frame {
+			t.Errorf("GET %s: got %v, want X-Frame-Options %q", test.path, w.Header(), test.frame)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	rr := New()
+	var order []string
+	rr.Use(trace(&order, "middleware"))
+	enabled := false
+	rr.Get("/(?P<id>[a-z]+)", func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "first "+rr.Params(r).Get("id"))
+		if !enabled {
+			rr.Next(w, r)
+			return
+		}
+
+		io.WriteString(w, "first")
+	}, false)
+	rr.Post("/abc", okHandler, false)
+	rr.Get("/(?P<name>.+)", func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "second "+rr.Params(r).Get("name")+" "+rr.Params(r).Get("id"))
+		io.WriteString(w, "second")
+	}, false)
+
+	// The next route gets its own params, skipping routes of other methods,
+	// and middleware doesn't run again
+	w := serve(rr, http.MethodGet, "/abc")
+	if want := "[middleware first abc second abc ]"; w.Body.String() != "second" || fmt.Sprint(order) != want {
+		t.Errorf("GET /abc: got %q, order %q, want %s", w.Body, order, want)
+	}
+	if w := serve(rr, http.MethodHead, "/abc"); w.Code != http.StatusOK || w.Body.String() != "second" {
+		t.Errorf("HEAD /abc: got %d %q, want the GET route", w.Code, w.Body)
+	}
+
+	enabled = true
+	if w := serve(rr, http.MethodGet, "/abc"); w.Body.String() != "first" {
+		t.Errorf("GET /abc without Next: got %q, want first", w.Body)
+	}
+
+	// Cached matches resume the same way
+	rr.MatchCache(10)
+	enabled = false
+	for i := 0; i < 2; i++ {
+		if w := serve(rr, http.MethodGet, "/abc"); w.Body.String() != "second" {
+			t.Errorf("GET /abc with a cache: got %q, want second", w.Body)
+		}
+	}
+
+	// Passing from the last route is a miss
+	last := New()
+	last.Get("/x", func(w http.ResponseWriter, r *http.Request) { last.Next(w, r) }, false)
+	if w := serve(last, http.MethodGet, "/x"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /x passing from the last route: got %d, want 404", w.Code)
+	}
+}
+
+func TestPanicData(t *testing.T) {
+	rr := New()
+	var got map[string]interface{}
+	rr.Handlers.ErrorCodes[http.StatusInternalServerError] = func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+		got = data
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	rr.Post("/panic", func(w http.ResponseWriter, r *http.Request) { panic("boom") }, false)
+
+	serve(rr, http.MethodPost, "/panic")
+	id, _ := got["request_id"].(string)
+	if got["exception"] != "boom" || got["method"] != http.MethodPost || got["path"] != "/panic" || got["stack"] == nil || len(id) != 32 {
+		t.Errorf("POST /panic: got data %v", got)
+	}
+
+	// An incoming request ID is used
+	r := httptest.NewRequest(http.MethodPost, "/panic", nil)
+	r.Header.Set("X-Request-ID", "abc")
+	rr.ServeHTTP(httptest.NewRecorder(), r)
+	if got["request_id"] != "abc" {
+		t.Errorf("POST /panic with a request ID: got %v, want abc", got["request_id"])
+	}
+}
+
+func TestSharedRegex(t *testing.T) {
+	rr := New()
+	rr.Get(`/users/(?P<id>\d+)`, okHandler, false)
+	rr.Put(`/users/(?P<id>\d+)`, okHandler, false)
+	rr.Delete(`/users/(?P<id>\d+)`, okHandler, false)
+	rr.Get(`/posts/(?P<id>\d+)`, okHandler, false)
+
+	routes := rr.routes
+	if routes[0].regex() != routes[1].regex() || routes[1].regex() != routes[2].regex() {
+		t.Error("routes with the same pattern got different regexes")
+	}
+	if routes[0].regex() == routes[3].regex() {
+		t.Error("routes with different patterns got the same regex")
+	}
+
+	// The regex is kept while a route still uses it
+	shared := routes[0].regex()
+	rr.Remove(http.MethodGet, `/users/(?P<id>\d+)`)
+	rr.Patch(`/users/(?P<id>\d+)`, okHandler, false)
+	if regex := rr.routes[len(rr.routes)-1].regex(); regex != shared {
+		t.Error("route added after a Remove got a new regex for a pattern still in use")
+	}
+	if w := serve(rr, http.MethodPatch, "/users/5"); w.Code != http.StatusOK {
+		t.Errorf("PATCH /users/5: got %d, want 200", w.Code)
+	}
+
+	// Once unused it's evicted
+	rr.Remove(http.MethodPut, `/users/(?P<id>\d+)`)
+	rr.Remove(http.MethodDelete, `/users/(?P<id>\d+)`)
+	rr.Remove(http.MethodPatch, `/users/(?P<id>\d+)`)
+	if _, ok := rr.regexes[shared.String()]; ok {
+		t.Error("regex of removed routes is still cached")
+	}
+}
+
+func TestMaxHeaderBytes(t *testing.T) {
+	rr := New()
+	rr.Get("/a", okHandler, false)
+	rr.MaxHeaderBytes(200)
+
+	r := httptest.NewRequest(http.MethodGet, "/a", nil)
+	r.Header.Set("X-Small", "1")
+	w := httptest.NewRecorder()
+	if rr.ServeHTTP(w, r); w.Code != http.StatusOK {
+		t.Errorf("GET /a with small headers: got %d, want 200", w.Code)
+	}
+
+	r.Header.Set("X-Big", strings.Repeat("a", 300))
+	w = httptest.NewRecorder()
+	rr.ServeHTTP(w, r)
+	if w.Code != http.StatusRequestHeaderFieldsTooLarge || !strings.HasPrefix(w.Body.String(), "431 - ") {
+		t.Errorf("GET /a with oversized headers: got %d %q, want 431", w.Code, w.Body)
+	}
+
+	// The Host header counts too
+	if size := headerSize(httptest.NewRequest(http.MethodGet, "http://h/", nil)); size != len("Host: h\r\n") {
+		t.Errorf("headerSize of a bare request: got %d, want %d", size, len("Host: h\r\n"))
+	}
+}
@@ -0,0 +1,360 @@
+package regrouter
+
+import (
+	"container/list"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Matcher matches a request path, returning the captured params
+type Matcher interface {
+	Match(path string) (captures map[string]string, ok bool)
+}
+
+// RegexMatcher is the default Matcher, capturing the named groups of the
+// regex and the unnamed ones by index
+type RegexMatcher struct {
+	Regexp *regexp.Regexp
+}
+
+// Match matches the path against the regex
+func (m RegexMatcher) Match(path string) (map[string]string, bool) {
+	matches := m.Regexp.FindStringSubmatch(path)
+	if matches == nil {
+		return nil, false
+	}
+
+	captures := make(map[string]string, len(matches))
+	for i, name := range m.Regexp.SubexpNames() {
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+
+		if _, ok := captures[name]; !ok {
+			captures[name] = matches[i]
+		}
+	}
+
+	return captures, true
+}
+
+// regex returns the regex of a route with the default matcher, or nil
+func (route Route) regex() *regexp.Regexp {
+	if m, ok := route.matcher.(RegexMatcher); ok {
+		return m.Regexp
+	}
+
+	return nil
+}
+
+// matcher iterates the routes whose pattern matches a path, in order. With a
+// MatchCache it first replays the routes an earlier scan of the path found,
+// then resumes that scan where it stopped.
+type matcher struct {
+	routes     []Route
+	path       string
+	lists      [3][]int // Routes under the literal path, first segment or unindexed.
+	pos        [3]int
+	limit      int // Patterns tested before giving up, if limited.
+	attempts   int
+	done       bool // Whether the scan reached the last route or the limit.
+	exceeded   bool // Whether matching gives up at the limit.
+	cache      *matchCache
+	generation uint64
+	candidates []candidate // Matching routes found by the scan.
+	i          int
+	extended   bool // Whether the scan went further than the cached one.
+}
+
+// routeIndex groups the routes by the literal first path segment of their
+// pattern, so a path is only tested against the routes that can match it.
+// Routes with a literal pattern are looked up in a trie instead.
+type routeIndex struct {
+	literals  *trieNode
+	segments  map[string][]int
+	unindexed []int // Routes without a literal first segment or compiled pattern.
+}
+
+// trieNode is a path segment of the literal route patterns
+type trieNode struct {
+	children map[string]*trieNode
+	routes   []int // Routes whose pattern ends at the segment.
+}
+
+// newRouteIndex indexes the routes by literal pattern or first segment
+func newRouteIndex(routes []Route) routeIndex {
+	index := routeIndex{literals: &trieNode{}, segments: map[string][]int{}}
+	for i, route := range routes {
+		// A regex of a custom matcher may not be anchored
+		if !route.compiled {
+			index.unindexed = append(index.unindexed, i)
+		} else if route.literal() {
+			index.literals.insert(route.pattern, i)
+		} else if segment, ok := firstSegment(route.regex()); ok {
+			index.segments[segment] = append(index.segments[segment], i)
+		} else {
+			index.unindexed = append(index.unindexed, i)
+		}
+	}
+
+	return index
+}
+
+// literal reports whether the route only matches its pattern as is
+func (route Route) literal() bool {
+	regex := route.regex()
+	return regex != nil && regex.String() == "^"+route.pattern+"$" && regexp.QuoteMeta(route.pattern) == route.pattern
+}
+
+// insert adds the route under the path
+func (node *trieNode) insert(path string, route int) {
+	for _, segment := range strings.Split(path, "/") {
+		child := node.children[segment]
+		if child == nil {
+			if node.children == nil {
+				node.children = map[string]*trieNode{}
+			}
+
+			child = &trieNode{}
+			node.children[segment] = child
+		}
+
+		node = child
+	}
+
+	node.routes = append(node.routes, route)
+}
+
+// lookup returns the routes under the path
+func (node *trieNode) lookup(path string) []int {
+	for node != nil {
+		i := strings.IndexByte(path, '/')
+		if i < 0 {
+			node = node.children[path]
+			break
+		}
+
+		node, path = node.children[path[:i]], path[i+1:]
+	}
+
+	if node == nil {
+		return nil
+	}
+
+	return node.routes
+}
+
+// firstSegment returns the first path segment every match of the regex has,
+// if the regex starts with the whole segment as a literal. Without a regex
+// there is none.
+func firstSegment(regex *regexp.Regexp) (string, bool) {
+	if regex == nil {
+		return "", false
+	}
+
+	prefix, complete := regex.LiteralPrefix()
+	if !strings.HasPrefix(prefix, "/") {
+		return "", false
+	}
+
+	prefix = prefix[1:]
+	if i := strings.IndexByte(prefix, '/'); i >= 0 {
+		return prefix[:i], true
+	}
+
+	return prefix, complete
+}
+
+// pathSegment returns the first segment of a path
+func pathSegment(path string) string {
+	path = strings.TrimPrefix(path, "/")
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		return path[:i]
+	}
+
+	return path
+}
+
+// candidate is a route whose pattern matched a path
+type candidate struct {
+	index   int
+	matches []string
+}
+
+// matcher returns a matcher for the path, the caller holding the read lock
+func (rr *RegRouter) matcher(path string) matcher {
+	m := matcher{routes: rr.routes, path: path, lists: [3][]int{rr.index.literals.lookup(path), rr.index.segments[pathSegment(path)], rr.index.unindexed}, limit: rr.maxMatchAttempts}
+	if rr.cache == nil {
+		return m
+	}
+
+	// Pick up the scan of the path where it stopped, or start it to be cached
+	m.cache, m.generation = rr.cache, rr.generation
+	if entry, ok := rr.cache.get(path, rr.generation); ok {
+		m.candidates, m.pos, m.attempts, m.done, m.exceeded = entry.candidates, entry.pos, entry.attempts, entry.done, entry.exceeded
+	} else {
+		m.extended = true
+	}
+
+	return m
+}
+
+// next returns the index and regex matches of the next matching route
+func (m *matcher) next() (int, []string, bool) {
+	if m.i < len(m.candidates) {
+		c := m.candidates[m.i]
+		m.i++
+		return c.index, c.matches, true
+	} else if m.done {
+		return 0, nil, false
+	}
+
+	index, matches, ok := m.scan()
+	if m.cache == nil {
+		return index, matches, ok
+	}
+
+	// Copy the cached candidates before adding to them
+	if !m.extended {
+		m.candidates = append([]candidate(nil), m.candidates...)
+		m.extended = true
+	}
+
+	if ok {
+		m.candidates = append(m.candidates, candidate{index, matches})
+		m.i++
+	}
+
+	return index, matches, ok
+}
+
+// save caches how far the scan got, if further than the cached one
+func (m *matcher) save() {
+	if m.cache != nil && m.extended {
+		m.cache.add(&cacheEntry{path: m.path, generation: m.generation, candidates: m.candidates, pos: m.pos, attempts: m.attempts, done: m.done, exceeded: m.exceeded})
+	}
+}
+
+// scan tests the remaining routes that can match the path, in order for the
+// next match. Routes under the literal path match without a test or matches,
+// the others counting towards the limit.
+func (m *matcher) scan() (int, []string, bool) {
+	for {
+		list := -1
+		for l := range m.lists {
+			if m.pos[l] < len(m.lists[l]) && (list < 0 || m.lists[l][m.pos[l]] < m.lists[list][m.pos[list]]) {
+				list = l
+			}
+		}
+
+		if list < 0 {
+			m.done = true
+			return 0, nil, false
+		}
+
+		index := m.lists[list][m.pos[list]]
+		m.pos[list]++
+		if list == 0 {
+			return index, nil, true
+		} else if m.limit > 0 && m.attempts >= m.limit {
+			m.done, m.exceeded = true, true
+			return 0, nil, false
+		}
+
+		m.attempts++
+		if matches := m.routes[index].match(m.path); matches != nil {
+			return index, matches, true
+		}
+	}
+}
+
+// MaxMatchAttempts limits the route patterns tested per request, a request
+// that tests more getting a 404 error. Routes with a literal pattern don't
+// count. The scans in the MatchCache are dropped, having been limited by the
+// former limit. A limit of 0 disables it.
+func (rr *RegRouter) MaxMatchAttempts(limit int) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	rr.maxMatchAttempts = limit
+	if rr.cache != nil {
+		rr.cache = newMatchCache(rr.cache.size)
+	}
+}
+
+// MatchCache caches the routes matching up to size recently requested paths,
+// skipping the pattern tests for repeated paths. A size of 0 disables it.
+func (rr *RegRouter) MatchCache(size int) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	rr.cache = nil
+	if size > 0 {
+		rr.cache = newMatchCache(size)
+	}
+}
+
+// newMatchCache returns an empty matchCache of the size
+func newMatchCache(size int) *matchCache {
+	return &matchCache{size: size, entries: map[string]*list.Element{}, order: list.New()}
+}
+
+// matchCache is an LRU cache of the routes matching a path
+type matchCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+// cacheEntry is how far a scan of a path got for a generation of the routes,
+// the routes it found matching and where to resume it
+type cacheEntry struct {
+	path       string
+	generation uint64
+	candidates []candidate
+	pos        [3]int
+	attempts   int
+	done       bool
+	exceeded   bool
+}
+
+// get returns the cached scan of the path
+func (mc *matchCache) get(path string, generation uint64) (*cacheEntry, bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	element, ok := mc.entries[path]
+	if !ok {
+		return nil, false
+	}
+
+	// Routes changed since the entry was added
+	entry := element.Value.(*cacheEntry)
+	if entry.generation != generation {
+		mc.order.Remove(element)
+		delete(mc.entries, path)
+		return nil, false
+	}
+
+	mc.order.MoveToFront(element)
+	return entry, true
+}
+
+// add caches the scan of a path, evicting the least recently used
+func (mc *matchCache) add(entry *cacheEntry) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if element, ok := mc.entries[entry.path]; ok {
+		mc.order.Remove(element)
+	} else if mc.order.Len() >= mc.size {
+		oldest := mc.order.Back()
+		mc.order.Remove(oldest)
+		delete(mc.entries, oldest.Value.(*cacheEntry).path)
+	}
+
+	mc.entries[entry.path] = mc.order.PushFront(entry)
+}
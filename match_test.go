@@ -0,0 +1,421 @@
+package regrouter
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// cacheRouter returns a RegRouter with 50 regex routes the index can't narrow
+// down and a match cache of the size, 0 disabling it
+func cacheRouter(size int) *RegRouter {
+	rr := New()
+	rr.MatchCache(size)
+	for i := 0; i < 50; i++ {
+		i := i
+		rr.Get(fmt.Sprintf(`/(?P<section>r%d)/(?P<id>\d+)`, i), func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, i, " ", rr.Params(r).Get("id"))
+		}, false)
+	}
+	rr.Post(`/(?P<section>r3)/(?P<id>\d+)`, okHandler, true)
+	return rr
+}
+
+// sameResponse reports whether the responses have the same code, headers and
+// body
+func sameResponse(a, b *httptest.ResponseRecorder) bool {
+	return a.Code == b.Code && a.Body.String() == b.Body.String() && fmt.Sprint(a.Header()) == fmt.Sprint(b.Header())
+}
+
+func TestMatchCache(t *testing.T) {
+	uncached, cached := cacheRouter(0), cacheRouter(4)
+	paths := []string{"/r1/5", "/r3/7", "/r49/1", "/missing", "/r3/x", "/r10/10"}
+
+	// Repeat the requests so later ones are answered from the cache
+	for n := 0; n < 3; n++ {
+		for _, path := range paths {
+			for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut} {
+				if want, got := serve(uncached, method, path), serve(cached, method, path); !sameResponse(want, got) {
+					t.Errorf("%s %s: got %d %q, want %d %q", method, path, got.Code, got.Body, want.Code, want.Body)
+				}
+			}
+		}
+	}
+
+	// Changing the routes invalidates the cached matches
+	cached.Remove(http.MethodGet, `/(?P<section>r1)/(?P<id>\d+)`)
+	if w := serve(cached, http.MethodGet, "/r1/5"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /r1/5 after Remove: got %d, want 404", w.Code)
+	}
+	cached.Get(`/(?P<section>r1)/(?P<id>\d+)`, okHandler, false)
+	if w := serve(cached, http.MethodGet, "/r1/5"); w.Body.String() != "ok" {
+		t.Errorf("GET /r1/5 after Get: got %d %q, want ok", w.Code, w.Body)
+	}
+}
+
+func TestMaxMatchAttempts(t *testing.T) {
+	rr := cacheRouter(0)
+	var limited []string
+	rr.Handlers.MatchLimit = func(r *http.Request) { limited = append(limited, r.URL.Path) }
+	rr.MaxMatchAttempts(10)
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/r5/1", http.StatusOK},
+		{"/r9/1", http.StatusOK},
+		{"/r10/1", http.StatusNotFound},
+		{"/r49/1", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		if w := serve(rr, http.MethodGet, test.path); w.Code != test.code {
+			t.Errorf("GET %s: got %d, want %d", test.path, w.Code, test.code)
+		}
+	}
+	if fmt.Sprint(limited) != "[/r10/1 /r49/1]" {
+		t.Errorf("MatchLimit calls: got %q", limited)
+	}
+
+	rr.MaxMatchAttempts(0)
+	if w := serve(rr, http.MethodGet, "/r49/1"); w.Code != http.StatusOK {
+		t.Errorf("GET /r49/1 without a limit: got %d, want 200", w.Code)
+	}
+}
+
+func TestMatchCacheMaxMatchAttempts(t *testing.T) {
+	uncached, cached := cacheRouter(0), cacheRouter(16)
+	limited := map[*RegRouter]int{}
+	for _, rr := range []*RegRouter{uncached, cached} {
+		rr := rr
+		rr.MaxMatchAttempts(10)
+		rr.Handlers.MatchLimit = func(r *http.Request) { limited[rr]++ }
+	}
+
+	for _, limit := range []int{10, 60, 2} {
+		uncached.MaxMatchAttempts(limit)
+		cached.MaxMatchAttempts(limit)
+		for n := 0; n < 3; n++ {
+			for _, path := range []string{"/r1/5", "/r9/5", "/r10/5", "/r49/1", "/missing"} {
+				if want, got := serve(uncached, http.MethodGet, path), serve(cached, http.MethodGet, path); !sameResponse(want, got) {
+					t.Errorf("limit %d GET %s: got %d %q, want %d %q", limit, path, got.Code, got.Body, want.Code, want.Body)
+				}
+			}
+		}
+	}
+
+	if limited[cached] == 0 || limited[cached] != limited[uncached] {
+		t.Errorf("MatchLimit calls: got %d, want %d", limited[cached], limited[uncached])
+	}
+
+	// Paths past the limit are still cached
+	if _, ok := cached.cache.entries["/r49/1"]; !ok {
+		t.Error("path past the limit wasn't cached")
+	}
+}
+
+func BenchmarkNoMatchCache(b *testing.B) {
+	rr := cacheRouter(0)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		serve(rr, http.MethodGet, "/r49/1")
+	}
+}
+
+func BenchmarkMatchCache(b *testing.B) {
+	rr := cacheRouter(128)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		serve(rr, http.MethodGet, "/r49/1")
+	}
+}
+
+// indexRouter returns a RegRouter with 500 routes of mixed priorities, 200 of
+// them without a literal first segment
+func indexRouter() *RegRouter {
+	rr := New()
+	for i := 0; i < 100; i++ {
+		rr.Get(fmt.Sprintf(`/s%d/(?P<id>\d+)`, i), okHandler, false)
+		rr.AddWithPriority(http.MethodGet, fmt.Sprintf(`/s%d/v(?P<id>\d+)?`, i), okHandler, false, i%3)
+		rr.AddPrefix(http.MethodGet, fmt.Sprintf("/s%d/files", i), okHandler, false)
+	}
+	for i := 0; i < 200; i++ {
+		rr.AddWithPriority(http.MethodGet, fmt.Sprintf("/(?P<x>s%d)/y", i), okHandler, false, i%2)
+	}
+
+	return rr
+}
+
+// indexedMatches returns the routes the index matches with the path, in order
+func indexedMatches(rr *RegRouter, path string) []int {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	var matched []int
+	m := rr.matcher(path)
+	for i, _, ok := m.next(); ok; i, _, ok = m.next() {
+		matched = append(matched, i)
+	}
+
+	return matched
+}
+
+// linearMatches returns the routes matching the path by testing each in turn
+func linearMatches(rr *RegRouter, path string) []int {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	var matched []int
+	for i, route := range rr.routes {
+		if route.match(path) != nil {
+			matched = append(matched, i)
+		}
+	}
+
+	return matched
+}
+
+func TestRouteIndex(t *testing.T) {
+	rr := indexRouter()
+	random := rand.New(rand.NewSource(1))
+	for n := 0; n < 5000; n++ {
+		var path string
+		switch random.Intn(5) {
+		case 0:
+			path = fmt.Sprintf("/s%d/%d", random.Intn(120), random.Intn(3))
+		case 1:
+			path = fmt.Sprintf("/s%d/v", random.Intn(120))
+		case 2:
+			path = fmt.Sprintf("/s%d/y", random.Intn(220))
+		case 3:
+			path = fmt.Sprintf("/s%d/files/%d", random.Intn(120), random.Intn(3))
+		case 4:
+			path = fmt.Sprintf("/s%dx/y", random.Intn(20))
+		}
+
+		if got, want := indexedMatches(rr, path), linearMatches(rr, path); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("%s: got routes %v, want %v", path, got, want)
+		}
+	}
+}
+
+func BenchmarkRouteIndex(b *testing.B) {
+	rr := indexRouter()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		indexedMatches(rr, "/s95/1")
+	}
+}
+
+func BenchmarkLinearMatch(b *testing.B) {
+	rr := indexRouter()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		linearMatches(rr, "/s95/1")
+	}
+}
+
+// trieRouter returns a RegRouter with 1000 literal and 50 regex routes, all
+// under /api/v1
+func trieRouter() *RegRouter {
+	rr := New()
+	for i := 0; i < 1000; i++ {
+		rr.Get(fmt.Sprintf("/api/v1/items%d/list", i), okHandler, false)
+	}
+	for i := 0; i < 50; i++ {
+		rr.Get(fmt.Sprintf(`/api/v1/things%d/(?P<id>\d+)`, i), okHandler, false)
+	}
+	rr.Get(`/api/v1/items(?P<n>\d+)/list`, okHandler, false)
+
+	return rr
+}
+
+func TestLiteralTrie(t *testing.T) {
+	rr := trieRouter()
+	paths := []string{"/api/v1", "/api/v1/", "/api/v1/items0/list", "/api/v1/items999/list", "/api/v1/items1000/list", "/api/v1/items5/list/", "/api/v1/things7/3", "/api/v1/things7/x", "/api/v2/items0/list", "//api/v1/items0/list"}
+	for _, path := range paths {
+		if got, want := indexedMatches(rr, path), linearMatches(rr, path); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s: got routes %v, want %v", path, got, want)
+		}
+	}
+
+	// The literal route matches before the regex route added after it
+	if info, _, matched := rr.Match(http.MethodGet, "/api/v1/items999/list"); !matched || info.Pattern != "/api/v1/items999/list" {
+		t.Errorf("Match of a literal route: got %t %v", matched, info)
+	}
+}
+
+func BenchmarkLiteralTrie(b *testing.B) {
+	rr := trieRouter()
+	for _, path := range []string{"/api/v1/items999/list", "/api/v1/things49/1"} {
+		b.Run(path, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				indexedMatches(rr, path)
+			}
+		})
+	}
+}
+
+func BenchmarkLiteralLinear(b *testing.B) {
+	rr := trieRouter()
+	for _, path := range []string{"/api/v1/items999/list", "/api/v1/things49/1"} {
+		b.Run(path, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				linearMatches(rr, path)
+			}
+		})
+	}
+}
+
+// exactMatcher matches only its path, capturing it as p
+type exactMatcher string
+
+func (m exactMatcher) Match(path string) (map[string]string, bool) {
+	return map[string]string{"p": path}, path == string(m)
+}
+
+// prefixMatcher matches paths with its prefix, capturing the rest
+type prefixMatcher string
+
+func (m prefixMatcher) Match(path string) (map[string]string, bool) {
+	if !strings.HasPrefix(path, string(m)) {
+		return nil, false
+	}
+
+	return map[string]string{"rest": strings.TrimPrefix(path, string(m))}, true
+}
+
+func TestRegexMatcher(t *testing.T) {
+	captures, ok := RegexMatcher{regexp.MustCompile(`^/(?P<a>\w+)/(\w+)$`)}.Match("/q/r")
+	if !ok || captures["0"] != "/q/r" || captures["a"] != "q" || captures["2"] != "r" {
+		t.Errorf("Match of /q/r: got %v, %t", captures, ok)
+	}
+	if _, ok := (RegexMatcher{regexp.MustCompile(`^/a$`)}).Match("/b"); ok {
+		t.Error("Match of /b: got true")
+	}
+}
+
+func TestAddMatcher(t *testing.T) {
+	rr := New()
+	var got string
+	rr.AddMatcher(http.MethodGet, "exact:/x", exactMatcher("/x"), func(w http.ResponseWriter, r *http.Request) {
+		got = rr.Params(r).Get("p")
+	}, false)
+	rr.AddMatcher(http.MethodGet, "prefix:/f/", prefixMatcher("/f/"), func(w http.ResponseWriter, r *http.Request) {
+		got = rr.Params(r).Get("rest") + " " + strings.Join(rr.Matches(r), ",")
+	}, false)
+	rr.Get(`/(?P<id>\d+)`, func(w http.ResponseWriter, r *http.Request) {
+		got = rr.Params(r).Get("id")
+	}, false)
+
+	tests := []struct {
+		method, path string
+		code         int
+		want         string
+	}{
+		{http.MethodGet, "/x", http.StatusOK, "/x"},
+		{http.MethodGet, "/f/a/b", http.StatusOK, "a/b /f/a/b"},
+		{http.MethodGet, "/12", http.StatusOK, "12"},
+		{http.MethodPost, "/x", http.StatusMethodNotAllowed, ""},
+		{http.MethodGet, "/y", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		got = ""
+		if w := serve(rr, test.method, test.path); w.Code != test.code || got != test.want {
+			t.Errorf("%s %s: got %d %q, want %d %q", test.method, test.path, w.Code, got, test.code, test.want)
+		}
+	}
+
+	// Custom matchers can't be compared, so they're never duplicates
+	rr.AddMatcher(http.MethodGet, "exact:/x", exactMatcher("/x"), okHandler, false)
+
+	// Nor are they recompiled
+	rr.CaseInsensitive(true)
+	if w := serve(rr, http.MethodGet, "/x"); w.Code != http.StatusOK {
+		t.Errorf("GET /x after CaseInsensitive: got %d, want 200", w.Code)
+	}
+	if info, _, found := rr.Match(http.MethodGet, "/f/z"); !found || info.Pattern != "prefix:/f/" {
+		t.Errorf("Match of /f/z: got %+v, %t", info, found)
+	}
+}
+
+func TestAddMatcherUnanchoredRegex(t *testing.T) {
+	rr := New()
+	rr.AddMatcher(http.MethodGet, "/a", RegexMatcher{regexp.MustCompile("/a")}, okHandler, false)
+	rr.AddMatcher(http.MethodGet, "/b/c", RegexMatcher{regexp.MustCompile("/b/c")}, okHandler, false)
+
+	// The regexes match anywhere in the path, not just under their literal
+	// prefix
+	for _, path := range []string{"/a", "/x/a", "/ab", "/x/b/c/d"} {
+		if w := serve(rr, http.MethodGet, path); w.Code != http.StatusOK {
+			t.Errorf("GET %s: got %d, want 200", path, w.Code)
+		}
+	}
+
+	// Nor are they recompiled or duplicates of a compiled pattern
+	rr.CaseInsensitive(true)
+	if w := serve(rr, http.MethodGet, "/x/A"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /x/A after CaseInsensitive: got %d, want 404", w.Code)
+	}
+	if err := rr.AddE(http.MethodGet, "/a", okHandler, false); err != nil {
+		t.Errorf("AddE of /a: got %v", err)
+	}
+}
+
+// countingMatcher matches the paths with its prefix, counting its tests
+type countingMatcher struct {
+	prefix string
+	tests  *int
+}
+
+func (m countingMatcher) Match(path string) (map[string]string, bool) {
+	*m.tests++
+	return map[string]string{}, strings.HasPrefix(path, m.prefix)
+}
+
+func TestMatchCacheScansLazily(t *testing.T) {
+	tests := 0
+	router := func(size int) *RegRouter {
+		rr := New()
+		rr.MatchCache(size)
+		rr.AddMatcher(http.MethodPost, "a", countingMatcher{"/a", &tests}, okHandler, false)
+		for i := 0; i < 49; i++ {
+			rr.AddMatcher(http.MethodGet, fmt.Sprint("none", i), countingMatcher{"/none", &tests}, okHandler, false)
+		}
+
+		return rr
+	}
+
+	// A miss stops at the first match, like an uncached scan
+	serve(router(0), http.MethodPost, "/a")
+	uncached := tests
+	rr := router(16)
+	tests = 0
+	if serve(rr, http.MethodPost, "/a"); tests != uncached {
+		t.Errorf("POST /a: got %d patterns tested, want %d", tests, uncached)
+	}
+
+	// A hit resumes the scan past the cached matches, then needs none
+	tests = 0
+	if w := serve(rr, http.MethodGet, "/a"); w.Code != http.StatusMethodNotAllowed || tests != 49 {
+		t.Errorf("GET /a: got %d with %d patterns tested, want 405 with 49", w.Code, tests)
+	}
+	tests = 0
+	if w := serve(rr, http.MethodGet, "/a"); w.Code != http.StatusMethodNotAllowed || tests != 0 {
+		t.Errorf("GET /a again: got %d with %d patterns tested, want 405 with 0", w.Code, tests)
+	}
+
+	// Unseen paths are limited too
+	rr.MaxMatchAttempts(10)
+	for i := 0; i < 5; i++ {
+		tests = 0
+		path := fmt.Sprint("/unique", i)
+		if w := serve(rr, http.MethodGet, path); w.Code != http.StatusNotFound || tests != 10 {
+			t.Errorf("GET %s: got %d with %d patterns tested, want 404 with 10", path, w.Code, tests)
+		}
+	}
+}
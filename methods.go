@@ -0,0 +1,43 @@
+package regrouter
+
+import "net/http"
+
+// Get adds a GET route to the RegRouter
+func (rr *RegRouter) Get(pattern string, handler http.HandlerFunc, cors bool) {
+	rr.Add(http.MethodGet, pattern, handler, cors)
+}
+
+// Post adds a POST route to the RegRouter
+func (rr *RegRouter) Post(pattern string, handler http.HandlerFunc, cors bool) {
+	rr.Add(http.MethodPost, pattern, handler, cors)
+}
+
+// Put adds a PUT route to the RegRouter
+func (rr *RegRouter) Put(pattern string, handler http.HandlerFunc, cors bool) {
+	rr.Add(http.MethodPut, pattern, handler, cors)
+}
+
+// Patch adds a PATCH route to the RegRouter
+func (rr *RegRouter) Patch(pattern string, handler http.HandlerFunc, cors bool) {
+	rr.Add(http.MethodPatch, pattern, handler, cors)
+}
+
+// Delete adds a DELETE route to the RegRouter
+func (rr *RegRouter) Delete(pattern string, handler http.HandlerFunc, cors bool) {
+	rr.Add(http.MethodDelete, pattern, handler, cors)
+}
+
+// Head adds a HEAD route to the RegRouter
+func (rr *RegRouter) Head(pattern string, handler http.HandlerFunc, cors bool) {
+	rr.Add(http.MethodHead, pattern, handler, cors)
+}
+
+// Options adds an OPTIONS route to the RegRouter
+func (rr *RegRouter) Options(pattern string, handler http.HandlerFunc, cors bool) {
+	rr.Add(http.MethodOptions, pattern, handler, cors)
+}
+
+// Any adds a route to the RegRouter matching every request method
+func (rr *RegRouter) Any(pattern string, handler http.HandlerFunc, cors bool) {
+	rr.Add(methodAny, pattern, handler, cors)
+}
@@ -0,0 +1,52 @@
+package regrouter
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMethodHelpers(t *testing.T) {
+	helpers := map[string]func(rr *RegRouter, pattern string, handler http.HandlerFunc, cors bool){
+		http.MethodGet:    (*RegRouter).Get,
+		http.MethodPost:   (*RegRouter).Post,
+		http.MethodPut:    (*RegRouter).Put,
+		http.MethodPatch:  (*RegRouter).Patch,
+		http.MethodDelete: (*RegRouter).Delete,
+	}
+	for method, add := range helpers {
+		rr := New()
+		add(rr, "/a", okHandler, false)
+
+		if routes := rr.Routes(); len(routes) != 1 || routes[0].Method != method || routes[0].Pattern != "/a" {
+			t.Errorf("%s helper: got routes %+v", method, routes)
+		}
+		if w := serve(rr, method, "/a"); w.Code != http.StatusOK {
+			t.Errorf("%s /a: got %d, want 200", method, w.Code)
+		}
+	}
+}
+
+func TestAny(t *testing.T) {
+	rr := New()
+	var got string
+	rr.Get("/x", func(w http.ResponseWriter, r *http.Request) { got = "get" }, false)
+	rr.Any("/x", func(w http.ResponseWriter, r *http.Request) { got = "any " + r.Method }, false)
+	rr.Any("/y", okHandler, false)
+
+	// An earlier route for the method matches first
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodDelete} {
+		want := "any " + method
+		if method == http.MethodGet {
+			want = "get"
+		}
+		if serve(rr, method, "/x"); got != want {
+			t.Errorf("%s /x: got %q, want %q", method, got, want)
+		}
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodDelete, "PROPFIND"} {
+		if w := serve(rr, method, "/y"); w.Code != http.StatusOK {
+			t.Errorf("%s /y: got %d, want 200", method, w.Code)
+		}
+	}
+}
@@ -1,10 +1,32 @@
 package regrouter
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+)
 
-// Params holds the HTTP params
+// ErrNoSuchParam is returned when a requested param doesn't exist
+var ErrNoSuchParam = errors.New("no such param")
+
+// Params holds the HTTP params, with the query string values kept apart from
+// the path params. Path params are decoded, being matched in the decoded path
+// or decoded once matched with MatchRawPath. A value that isn't validly
+// encoded is kept as is.
 type Params struct {
-	Values map[string]string
+	Values  map[string]string
+	all     map[string][]string // Values of the repeated params.
+	raw     map[string]string   // Escaped values of the decoded params.
+	query   url.Values
+	route   RouteInfo // Route the params were matched by.
+	routed  bool
+	matches []string
+	parent  *Params // Params of an enclosing RegRouter, if any.
+	router  *ctxKey // Key of the RegRouter storing the params.
+	routes  []Route // Routes the matched route is at the index of, for Next.
+	index   int
 }
 
 // GetE returns a param or error
@@ -13,7 +35,7 @@ func (p Params) GetE(key string) (string, error) {
 		return res, nil
 	}
 
-	return "", fmt.Errorf("%q: no such param", key)
+	return "", fmt.Errorf("%q: %w", key, ErrNoSuchParam)
 }
 
 // Get returns a param or empty string
@@ -21,9 +43,139 @@ func (p Params) Get(key string) string {
 	return p.Values[key]
 }
 
-// Set adds a param
+// GetDefault returns a param or the fallback if it doesn't exist
+func (p Params) GetDefault(key string, fallback string) string {
+	if res, ok := p.Values[key]; ok {
+		return res
+	}
+
+	return fallback
+}
+
+// GetRaw returns a param as it was in the escaped path, or Get if it wasn't
+// decoded
+func (p Params) GetRaw(key string) string {
+	if res, ok := p.raw[key]; ok {
+		return res
+	}
+
+	return p.Values[key]
+}
+
+// Has reports whether a param exists
+func (p Params) Has(key string) bool {
+	_, ok := p.Values[key]
+	return ok
+}
+
+// Keys returns the param names in sorted order
+func (p Params) Keys() []string {
+	keys := make([]string, 0, len(p.Values))
+	for key := range p.Values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// GetAll returns every value of a repeated param
+func (p Params) GetAll(key string) []string {
+	if values, ok := p.all[key]; ok {
+		return values
+	} else if res, ok := p.Values[key]; ok {
+		return []string{res}
+	}
+
+	return nil
+}
+
+// Query returns the first query string value or empty string
+func (p Params) Query(key string) string {
+	return p.query.Get(key)
+}
+
+// QueryAll returns every value of a repeated query string key
+func (p Params) QueryAll(key string) []string {
+	return p.query[key]
+}
+
+// GetInt returns a param parsed as an int or error
+func (p Params) GetInt(key string) (int, error) {
+	res, err := p.GetE(key)
+	if err != nil {
+		return 0, err
+	}
+
+	i, err := strconv.Atoi(res)
+	if err != nil {
+		return 0, fmt.Errorf("%q: invalid int param: %w", key, err)
+	}
+
+	return i, nil
+}
+
+// GetIntDefault returns a param parsed as an int or the fallback if it doesn't exist or isn't an int
+func (p Params) GetIntDefault(key string, fallback int) int {
+	if i, err := p.GetInt(key); err == nil {
+		return i
+	}
+
+	return fallback
+}
+
+// GetBool returns a param parsed as a bool or error
+func (p Params) GetBool(key string) (bool, error) {
+	res, err := p.GetE(key)
+	if err != nil {
+		return false, err
+	}
+
+	b, err := strconv.ParseBool(res)
+	if err != nil {
+		return false, fmt.Errorf("%q: invalid bool param: %w", key, err)
+	}
+
+	return b, nil
+}
+
+// GetFloat returns a param parsed as a float64 or error
+func (p Params) GetFloat(key string) (float64, error) {
+	res, err := p.GetE(key)
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := strconv.ParseFloat(res, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q: invalid float param: %w", key, err)
+	}
+
+	return f, nil
+}
+
+// Set adds a param, reporting whether it replaced an existing value
 func (p Params) Set(key string, value string) bool {
-	p.Values[key] = value
 	_, ok := p.Values[key]
+	p.Values[key] = value
+	delete(p.all, key)
+	delete(p.raw, key)
 	return ok
 }
+
+// Add appends a value to a param, keeping the first value for Get
+func (p Params) Add(key string, value string) {
+	first, ok := p.Values[key]
+	if !ok {
+		p.Values[key] = value
+		return
+	}
+
+	if p.all != nil {
+		if _, ok := p.all[key]; !ok {
+			p.all[key] = []string{first}
+		}
+
+		p.all[key] = append(p.all[key], value)
+	}
+}
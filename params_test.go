@@ -0,0 +1,354 @@
+package regrouter
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestParamsCaptures(t *testing.T) {
+	rr := New()
+	var got Params
+	var route RouteInfo
+	var matches []string
+	record := func(w http.ResponseWriter, r *http.Request) {
+		got = rr.Params(r)
+		route, _ = rr.Route(r)
+		matches = rr.Matches(r)
+	}
+	rr.Get("/about", record, false)
+	rr.Get(`/users/(?P<id>\d+)/posts/(?P<post>\d+)`, record, false)
+	rr.Get(`/pairs/(?P<key>\w+)/(?P<key>\w+)/(\d+)`, record, false)
+
+	serve(rr, http.MethodGet, "/about")
+	if fmt.Sprint(got.Keys()) != "[0]" || got.Get("0") != "/about" || got.Query("x") != "" || route.Pattern != "/about" {
+		t.Errorf("GET /about: got params %v, route %q", got.Values, route.Pattern)
+	}
+	if fmt.Sprint(matches) != "[/about]" {
+		t.Errorf("GET /about: got matches %q", matches)
+	}
+
+	serve(rr, http.MethodGet, "/about?x=1")
+	if got.Query("x") != "1" {
+		t.Errorf("GET /about?x=1: got query x %q, want 1", got.Query("x"))
+	}
+
+	serve(rr, http.MethodGet, "/users/1/posts/2?x=3")
+	if fmt.Sprint(got.Keys()) != "[0 id post]" || got.Get("id") != "1" || got.Get("post") != "2" || got.Query("x") != "3" {
+		t.Errorf("GET /users/1/posts/2?x=3: got %v, query x %q", got.Values, got.Query("x"))
+	}
+	if route.Pattern != `/users/(?P<id>\d+)/posts/(?P<post>\d+)` || fmt.Sprint(matches) != "[/users/1/posts/2 1 2]" {
+		t.Errorf("GET /users/1/posts/2: got route %q, matches %q", route.Pattern, matches)
+	}
+
+	// Repeated names keep every value, unnamed groups are keyed by index
+	serve(rr, http.MethodGet, "/pairs/a/b/7")
+	if fmt.Sprint(got.GetAll("key")) != "[a b]" || got.Get("3") != "7" || fmt.Sprint(got.GetAll("3")) != "[7]" {
+		t.Errorf("GET /pairs/a/b/7: got %v, all key %v", got.Values, got.GetAll("key"))
+	}
+}
+
+func TestMatches(t *testing.T) {
+	rr := New()
+	var got []string
+	record := func(w http.ResponseWriter, r *http.Request) { got = rr.Matches(r) }
+	rr.Get(`/d/(\d+)-(\d+)/(?P<n>\w+)`, record, false)
+	rr.Get("/lit", record, false)
+	rr.MatchCache(4)
+
+	// Cached matches are the same
+	for i := 0; i < 2; i++ {
+		if serve(rr, http.MethodGet, "/d/1-2/x"); fmt.Sprint(got) != "[/d/1-2/x 1 2 x]" {
+			t.Errorf("GET /d/1-2/x: got matches %q", got)
+		}
+		if serve(rr, http.MethodGet, "/lit"); fmt.Sprint(got) != "[/lit]" {
+			t.Errorf("GET /lit: got matches %q", got)
+		}
+	}
+
+	if matches := rr.Matches(httptest.NewRequest(http.MethodGet, "/", nil)); matches != nil {
+		t.Errorf("Matches of a bare request: got %q, want nil", matches)
+	}
+}
+
+func TestParamsAllocs(t *testing.T) {
+	// The allocations don't depend on how many routers came before
+	for i := 0; i < 300; i++ {
+		New().key()
+	}
+
+	rr := New()
+	h := func(w http.ResponseWriter, r *http.Request) { rr.Params(r) }
+	rr.Get("/", h, false)
+	rr.Get(`/users/(?P<id>\d+)/posts/(?P<post>\d+)`, h, false)
+	rr.Get("/about", h, false)
+
+	for path, limit := range map[string]float64{"/about": 8, "/users/1/posts/2": 10} {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		if allocs := testing.AllocsPerRun(100, func() { rr.ServeHTTP(w, r) }); allocs > limit {
+			t.Errorf("GET %s: got %v allocs, want at most %v", path, allocs, limit)
+		}
+	}
+}
+
+func BenchmarkHandler(b *testing.B) {
+	rr := New()
+	h := func(w http.ResponseWriter, r *http.Request) {}
+	rr.Get("/", h, false)
+	rr.Get(`/users/(?P<id>\d+)/posts/(?P<post>\d+)`, h, false)
+	rr.Get("/about", h, false)
+
+	for _, path := range []string{"/about", "/users/1/posts/2"} {
+		b.Run(path, func(b *testing.B) {
+			r := httptest.NewRequest(http.MethodGet, path, nil)
+			w := httptest.NewRecorder()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				rr.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+func TestParamsTyped(t *testing.T) {
+	p := Params{Values: map[string]string{"i": "12", "b": "true", "f": "1.5", "x": "zz"}}
+	if i, err := p.GetInt("i"); i != 12 || err != nil {
+		t.Errorf("GetInt: got %d, %v, want 12", i, err)
+	}
+	if b, err := p.GetBool("b"); !b || err != nil {
+		t.Errorf("GetBool: got %t, %v, want true", b, err)
+	}
+	if f, err := p.GetFloat("f"); f != 1.5 || err != nil {
+		t.Errorf("GetFloat: got %v, %v, want 1.5", f, err)
+	}
+
+	// Unparseable values wrap the parse error
+	if _, err := p.GetInt("x"); !errors.Is(err, strconv.ErrSyntax) || errors.Is(err, ErrNoSuchParam) {
+		t.Errorf("GetInt of an unparseable value: got %v", err)
+	}
+	if _, err := p.GetBool("x"); !errors.Is(err, strconv.ErrSyntax) {
+		t.Errorf("GetBool of an unparseable value: got %v", err)
+	}
+	if _, err := p.GetFloat("x"); !errors.Is(err, strconv.ErrSyntax) {
+		t.Errorf("GetFloat of an unparseable value: got %v", err)
+	}
+
+	// Absent keys are ErrNoSuchParam
+	if _, err := p.GetInt("missing"); !errors.Is(err, ErrNoSuchParam) {
+		t.Errorf("GetInt of an absent key: got %v", err)
+	}
+	if _, err := p.GetBool("missing"); !errors.Is(err, ErrNoSuchParam) {
+		t.Errorf("GetBool of an absent key: got %v", err)
+	}
+	if _, err := p.GetFloat("missing"); !errors.Is(err, ErrNoSuchParam) {
+		t.Errorf("GetFloat of an absent key: got %v", err)
+	}
+}
+
+func TestParamsDefaults(t *testing.T) {
+	p := Params{Values: map[string]string{"i": "12", "x": "zz", "empty": ""}}
+	tests := []struct {
+		key, fallback, want string
+	}{
+		{"x", "d", "zz"},
+		{"missing", "d", "d"},
+		{"empty", "d", ""},
+	}
+	for _, test := range tests {
+		if got := p.GetDefault(test.key, test.fallback); got != test.want {
+			t.Errorf("GetDefault(%q, %q): got %q, want %q", test.key, test.fallback, got, test.want)
+		}
+	}
+
+	for key, want := range map[string]int{"i": 12, "x": 3, "missing": 3} {
+		if got := p.GetIntDefault(key, 3); got != want {
+			t.Errorf("GetIntDefault(%q, 3): got %d, want %d", key, got, want)
+		}
+	}
+}
+
+func TestParamsQuery(t *testing.T) {
+	rr := New()
+	var path, query string
+	rr.Get(`/u/(?P<id>\d+)`, func(w http.ResponseWriter, r *http.Request) {
+		path, query = rr.Params(r).Get("id"), rr.Params(r).Query("id")
+	}, false)
+
+	// A path param and a query param of the same key are kept apart
+	serve(rr, http.MethodGet, "/u/1?id=2")
+	if path != "1" || query != "2" {
+		t.Errorf("GET /u/1?id=2: got path id %q, query id %q", path, query)
+	}
+
+	if got := (Params{}).Query("id"); got != "" {
+		t.Errorf("Query of empty Params: got %q", got)
+	}
+}
+
+func TestParamsRepeated(t *testing.T) {
+	rr := New()
+	var p Params
+	rr.Get(`/(?P<tag>[a-z]+)/(?P<tag>[a-z]+)/(?P<one>\d)`, func(w http.ResponseWriter, r *http.Request) { p = rr.Params(r) }, false)
+
+	serve(rr, http.MethodGet, "/a/b/1?f=x&f=y&g=z")
+	if p.Get("tag") != "a" || fmt.Sprint(p.GetAll("tag")) != "[a b]" {
+		t.Errorf("repeated param: got %q, all %q", p.Get("tag"), p.GetAll("tag"))
+	}
+	if fmt.Sprint(p.GetAll("one")) != "[1]" || p.GetAll("missing") != nil {
+		t.Errorf("single param: got all %q, missing %q", p.GetAll("one"), p.GetAll("missing"))
+	}
+	if fmt.Sprint(p.QueryAll("f")) != "[x y]" || fmt.Sprint(p.QueryAll("g")) != "[z]" {
+		t.Errorf("query: got all f %q, all g %q", p.QueryAll("f"), p.QueryAll("g"))
+	}
+
+	// Set replaces every value
+	p.Set("tag", "z")
+	if fmt.Sprint(p.GetAll("tag")) != "[z]" {
+		t.Errorf("repeated param after Set: got all %q", p.GetAll("tag"))
+	}
+}
+
+func TestParamsSet(t *testing.T) {
+	p := Params{Values: map[string]string{}}
+	if p.Set("a", "1") {
+		t.Error("Set of a new param: got true")
+	}
+	if !p.Set("a", "2") {
+		t.Error("Set of an existing param: got false")
+	}
+	if p.Get("a") != "2" {
+		t.Errorf("Get after Set: got %q, want 2", p.Get("a"))
+	}
+}
+
+func TestParamsKeys(t *testing.T) {
+	p := Params{Values: map[string]string{"b": "", "a": "1", "0": "x"}}
+	if !p.Has("b") || !p.Has("a") {
+		t.Error("Has of present params: got false")
+	}
+	if p.Has("c") {
+		t.Error("Has of an absent param: got true")
+	}
+	for i := 0; i < 5; i++ {
+		if got := fmt.Sprint(p.Keys()); got != "[0 a b]" {
+			t.Fatalf("Keys: got %s, want [0 a b]", got)
+		}
+	}
+}
+
+func TestParamsDecoded(t *testing.T) {
+	rr := New()
+	rr.MatchRawPath(true)
+	var got Params
+	rr.Get("/k/(?P<key>[^/]+)", func(w http.ResponseWriter, r *http.Request) {
+		got = rr.Params(r)
+	}, false)
+
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"a%20b", "a b"},
+		{"a%2Fb", "a/b"},
+		{"plain", "plain"},
+		{"a%25zz", "a%zz"},
+	}
+	for _, test := range tests {
+		w := serve(rr, http.MethodGet, "/k/"+test.raw)
+		if w.Code != http.StatusOK || got.Get("key") != test.want || got.GetRaw("key") != test.raw {
+			t.Errorf("GET /k/%s: got %d %q raw %q, want %q", test.raw, w.Code, got.Get("key"), got.GetRaw("key"), test.want)
+		}
+	}
+
+	// An invalid escape is kept verbatim
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.URL.Path, r.URL.RawPath = "/k/x%zz", "/k/x%zz"
+	rr.ServeHTTP(httptest.NewRecorder(), r)
+	if got.Get("key") != "x%zz" {
+		t.Errorf("invalid escape: got %q, want %q", got.Get("key"), "x%zz")
+	}
+
+	// Set replaces the raw value too
+	got.Set("key", "n")
+	if got.GetRaw("key") != "n" {
+		t.Errorf("GetRaw after Set: got %q, want %q", got.GetRaw("key"), "n")
+	}
+}
+
+func TestWithParams(t *testing.T) {
+	rr := New()
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		params := rr.Params(r)
+		fmt.Fprint(w, params.Get("id"), params.Query("q"))
+	}
+
+	values := map[string]string{"id": "7"}
+	r := rr.WithParams(httptest.NewRequest(http.MethodGet, "/?q=x", nil), values)
+
+	// The values are copied
+	values["id"] = "8"
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if w.Body.String() != "7x" {
+		t.Errorf("handler with injected params: got %q, want 7x", w.Body)
+	}
+
+	// No route is recorded
+	if _, ok := rr.Route(r); ok {
+		t.Error("Route of a request with injected params: got true")
+	}
+}
+
+func TestParamsMerged(t *testing.T) {
+	parent, child := New(), New()
+	var merged, own Params
+	child.Get("/posts/(?P<post>[0-9]+)", func(w http.ResponseWriter, r *http.Request) {
+		merged, own = child.ParamsMerged(r), child.Params(r)
+	}, false)
+	parent.Mount("/users/(?P<user>[a-z]+)", child)
+
+	if w := serve(parent, http.MethodGet, "/users/bob/posts/3"); w.Code != http.StatusOK || merged.Get("user") != "bob" || merged.Get("post") != "3" {
+		t.Errorf("GET /users/bob/posts/3: got %d merged %v", w.Code, merged.Values)
+	}
+	if own.Get("user") != "" {
+		t.Errorf("GET /users/bob/posts/3: got parent params %v in Params", own.Values)
+	}
+
+	// The child's params win
+	parent, child = New(), New()
+	var user string
+	child.Get("/(?P<user>[a-z]+)", func(w http.ResponseWriter, r *http.Request) {
+		user = child.ParamsMerged(r).Get("user")
+	}, false)
+	parent.Mount("/u/(?P<user>[a-z]+)", child)
+	if serve(parent, http.MethodGet, "/u/a/b"); user != "b" {
+		t.Errorf("GET /u/a/b: got user %q, want b", user)
+	}
+
+	// A router without a parent has only its own
+	if serve(child, http.MethodGet, "/z"); user != "z" {
+		t.Errorf("GET /z: got user %q, want z", user)
+	}
+}
+
+func TestExtractParams(t *testing.T) {
+	params, ok := ExtractParams(`/users/(?P<id>[0-9]+)/(\w+)`, "/users/12/posts")
+	if !ok || params.Get("0") != "/users/12/posts" || params.Get("id") != "12" || params.Get("2") != "posts" {
+		t.Errorf("ExtractParams of /users/12/posts: got %v, %t", params.Values, ok)
+	}
+	if params, ok := ExtractParams("/f/*rest", "/f/a/b"); !ok || params.Get("rest") != "a/b" {
+		t.Errorf("ExtractParams with a catch-all: got %v, %t", params.Values, ok)
+	}
+
+	tests := map[string]string{`/users/(?P<id>[0-9]+)`: "/users/x", "/(": "/"}
+	for pattern, path := range tests {
+		if _, ok := ExtractParams(pattern, path); ok {
+			t.Errorf("ExtractParams(%q, %q): got true", pattern, path)
+		}
+	}
+}
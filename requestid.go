@@ -0,0 +1,41 @@
+package regrouter
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// requestIDKey is the context key of the request ID
+type requestIDKey struct{}
+
+// UseRequestID gives every request an ID, its X-Request-ID header or else a
+// generated one, sent back in the X-Request-ID response header. Error handlers
+// get it as the "request_id" data.
+func (rr *RegRouter) UseRequestID() {
+	rr.requestIDs = true
+}
+
+// RequestID returns the ID of the request, or empty string if UseRequestID
+// isn't in use
+func (rr *RegRouter) RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestID returns the ID of the request, its X-Request-ID, or else a new
+// random ID
+func requestID(r *http.Request) string {
+	if id, ok := r.Context().Value(requestIDKey{}).(string); ok {
+		return id
+	} else if id := r.Header.Get("X-Request-ID"); id != "" {
+		return id
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(b)
+}
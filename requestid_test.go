@@ -0,0 +1,49 @@
+package regrouter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestIDIncoming(t *testing.T) {
+	rr := New()
+	rr.UseRequestID()
+	var seen string
+	rr.Get("/a", func(w http.ResponseWriter, r *http.Request) { seen = rr.RequestID(r) }, false)
+
+	r := httptest.NewRequest(http.MethodGet, "/a", nil)
+	r.Header.Set("X-Request-ID", "in-1")
+	w := httptest.NewRecorder()
+	rr.ServeHTTP(w, r)
+	if seen != "in-1" || w.Header().Get("X-Request-ID") != "in-1" {
+		t.Errorf("GET /a with a request ID: got %q, %v", seen, w.Header())
+	}
+
+	// Without UseRequestID there's none
+	if id := New().RequestID(r); id != "" {
+		t.Errorf("RequestID without UseRequestID: got %q", id)
+	}
+}
+
+func TestRequestIDGenerated(t *testing.T) {
+	rr := New()
+	rr.UseRequestID()
+	var seen string
+	rr.Get("/a", func(w http.ResponseWriter, r *http.Request) { seen = rr.RequestID(r) }, false)
+	var data map[string]interface{}
+	rr.Handlers.ErrorCodes[http.StatusNotFound] = func(d map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+		data = d
+		w.WriteHeader(http.StatusNotFound)
+	}
+
+	if w := serve(rr, http.MethodGet, "/a"); len(seen) != 32 || w.Header().Get("X-Request-ID") != seen {
+		t.Errorf("GET /a: got %q, %v", seen, w.Header())
+	}
+
+	// Error handlers get the ID
+	w := serve(rr, http.MethodGet, "/missing")
+	if id := w.Header().Get("X-Request-ID"); id == "" || data["request_id"] != id {
+		t.Errorf("GET /missing: got data %v, header %q", data, id)
+	}
+}
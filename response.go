@@ -0,0 +1,216 @@
+package regrouter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp/syntax"
+	"strings"
+)
+
+// JSON responds with the status and v encoded as JSON. Nothing is written if
+// v can't be encoded.
+func (rr *RegRouter) JSON(w http.ResponseWriter, status int, v interface{}) error {
+	return writeJSON(w, status, "application/json", v)
+}
+
+// writeJSON responds with the status and v encoded as JSON of the content type
+func writeJSON(w http.ResponseWriter, status int, contentType string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	_, err = w.Write(append(body, '\n'))
+	return err
+}
+
+// Text responds with the status and the string as plain text
+func (rr *RegRouter) Text(w http.ResponseWriter, status int, s string) error {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+// Redirect adds a GET route redirecting to another URL with the code, keeping
+// the query string. Params are substituted for $name or ${name} in the URL,
+// escaped like URL does, so they can't add to the query or change the host.
+func (rr *RegRouter) Redirect(pattern string, to string, code int) {
+	// An invalid pattern panics adding the route
+	groups := map[string]*syntax.Regexp{}
+	if re, err := syntax.Parse(expandCatchAll(pattern), syntax.Perl); err == nil {
+		captureGroups(re, groups)
+	}
+
+	toPath, toQuery := to, ""
+	if i := strings.Index(to, "?"); i >= 0 {
+		toPath, toQuery = to[:i], to[i:]
+	}
+
+	rr.Add(http.MethodGet, pattern, func(w http.ResponseWriter, r *http.Request) {
+		params := rr.Params(r)
+		target := os.Expand(toPath, func(key string) string {
+			return redirectParam(groups[key], params.Get(key))
+		}) + os.Expand(toQuery, func(key string) string {
+			return url.QueryEscape(params.Get(key))
+		})
+		if r.URL.RawQuery != "" {
+			if strings.Contains(target, "?") {
+				target += "&" + r.URL.RawQuery
+			} else {
+				target += "?" + r.URL.RawQuery
+			}
+		}
+
+		http.Redirect(w, r, target, code)
+	}, false)
+}
+
+// redirectParam escapes a param value for the path of a redirect URL given the
+// group capturing it. Slashes starting an empty segment are escaped too, as a
+// value can't make the URL scheme-relative.
+func redirectParam(group *syntax.Regexp, value string) string {
+	if group == nil {
+		return url.PathEscape(value)
+	}
+
+	var path urlPath
+	path.writeParam(group, value)
+	escaped := path.escaped.String()
+	if strings.HasPrefix(escaped, "/") {
+		escaped = "%2F" + escaped[1:]
+	}
+
+	return strings.ReplaceAll(escaped, "//", "/%2F")
+}
+
+// UseJSONErrors replaces the 404, 405, 406, 413, 431, 500, 503 and 504 error
+// handlers with ones responding in JSON
+func (rr *RegRouter) UseJSONErrors() {
+	if rr.Handlers.ErrorCodes == nil {
+		rr.Handlers.ErrorCodes = map[int]func(map[string]interface{}, http.ResponseWriter, *http.Request){}
+	}
+
+	rr.Handlers.ErrorCodes[http.StatusNotFound] = func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+		rr.jsonError(http.StatusNotFound, nil, w)
+	}
+	rr.Handlers.ErrorCodes[http.StatusMethodNotAllowed] = func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+		allowed, _ := data["allowed"].(string)
+		w.Header().Set("Allow", allowed)
+		rr.jsonError(http.StatusMethodNotAllowed, map[string]interface{}{"allowed": strings.Split(allowed, ", ")}, w)
+	}
+	rr.Handlers.ErrorCodes[http.StatusNotAcceptable] = func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+		rr.jsonError(http.StatusNotAcceptable, nil, w)
+	}
+	rr.Handlers.ErrorCodes[http.StatusRequestEntityTooLarge] = func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+		rr.jsonError(http.StatusRequestEntityTooLarge, nil, w)
+	}
+	rr.Handlers.ErrorCodes[http.StatusRequestHeaderFieldsTooLarge] = func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+		rr.jsonError(http.StatusRequestHeaderFieldsTooLarge, nil, w)
+	}
+	rr.Handlers.ErrorCodes[http.StatusInternalServerError] = func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+		rr.jsonError(http.StatusInternalServerError, map[string]interface{}{"exception": fmt.Sprint(data["exception"])}, w)
+	}
+	rr.Handlers.ErrorCodes[http.StatusServiceUnavailable] = func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+		rr.jsonError(http.StatusServiceUnavailable, nil, w)
+	}
+	rr.Handlers.ErrorCodes[http.StatusGatewayTimeout] = func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+		rr.jsonError(http.StatusGatewayTimeout, nil, w)
+	}
+}
+
+// UseProblemJSONErrors replaces the 404, 405, 406, 413, 431, 500, 503 and
+// 504 error handlers with ones responding with RFC 7807 problem details in JSON
+func (rr *RegRouter) UseProblemJSONErrors() {
+	if rr.Handlers.ErrorCodes == nil {
+		rr.Handlers.ErrorCodes = map[int]func(map[string]interface{}, http.ResponseWriter, *http.Request){}
+	}
+
+	rr.Handlers.ErrorCodes[http.StatusNotFound] = func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+		problemError(http.StatusNotFound, fmt.Sprintf("No route matches %s %s", r.Method, r.URL.Path), nil, w)
+	}
+	rr.Handlers.ErrorCodes[http.StatusMethodNotAllowed] = func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+		allowed, _ := data["allowed"].(string)
+		w.Header().Set("Allow", allowed)
+		problemError(http.StatusMethodNotAllowed, "Valid: "+allowed, map[string]interface{}{"allowed": strings.Split(allowed, ", ")}, w)
+	}
+	rr.Handlers.ErrorCodes[http.StatusNotAcceptable] = func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+		problemError(http.StatusNotAcceptable, "No media type the route produces is acceptable", nil, w)
+	}
+	rr.Handlers.ErrorCodes[http.StatusRequestEntityTooLarge] = func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+		problemError(http.StatusRequestEntityTooLarge, "The request body is too large", nil, w)
+	}
+	rr.Handlers.ErrorCodes[http.StatusRequestHeaderFieldsTooLarge] = func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+		problemError(http.StatusRequestHeaderFieldsTooLarge, "The request headers are too large", nil, w)
+	}
+	rr.Handlers.ErrorCodes[http.StatusInternalServerError] = func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+		problemError(http.StatusInternalServerError, "Exception: "+fmt.Sprint(data["exception"]), nil, w)
+	}
+	rr.Handlers.ErrorCodes[http.StatusServiceUnavailable] = func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+		problemError(http.StatusServiceUnavailable, "The server is not accepting new requests", nil, w)
+	}
+	rr.Handlers.ErrorCodes[http.StatusGatewayTimeout] = func(data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+		problemError(http.StatusGatewayTimeout, "The handler timed out", nil, w)
+	}
+}
+
+// negotiateError responds with a JSON error body for the code with the fields
+// if the request prefers JSON, or else a plain text error with the details
+func (rr *RegRouter) negotiateError(code int, details string, fields map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+	if prefersJSON(r) {
+		rr.jsonError(code, fields, w)
+	} else if details != "" {
+		http.Error(w, fmt.Sprintf("%d - %s (%s)\n", code, http.StatusText(code), details), code)
+	} else {
+		http.Error(w, fmt.Sprintf("%d - %s\n", code, http.StatusText(code)), code)
+	}
+}
+
+// prefersJSON reports whether the request accepts JSON with a higher quality
+// than text
+func prefersJSON(r *http.Request) bool {
+	var jsonQ, textQ float64
+	for _, accepted := range acceptRanges(r) {
+		switch {
+		case accepted.mediaType == "application/json" || strings.HasSuffix(accepted.mediaType, "+json"):
+			jsonQ = math.Max(jsonQ, accepted.q)
+		case strings.HasPrefix(accepted.mediaType, "text/") || accepted.mediaType == "*/*":
+			textQ = math.Max(textQ, accepted.q)
+		}
+	}
+
+	return jsonQ > textQ
+}
+
+// jsonError responds with a JSON error body for the code, with any extra
+// fields
+func (rr *RegRouter) jsonError(code int, fields map[string]interface{}, w http.ResponseWriter) {
+	body := map[string]interface{}{"error": http.StatusText(code), "code": code}
+	for key, value := range fields {
+		body[key] = value
+	}
+
+	w.Header().Del("Content-Length")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	rr.JSON(w, code, body)
+}
+
+// problemError responds with RFC 7807 problem details for the code, with any
+// extension fields
+func problemError(code int, detail string, fields map[string]interface{}, w http.ResponseWriter) {
+	body := map[string]interface{}{"type": "about:blank", "title": http.StatusText(code), "status": code, "detail": detail}
+	for key, value := range fields {
+		body[key] = value
+	}
+
+	w.Header().Del("Content-Length")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	writeJSON(w, code, "application/problem+json", body)
+}
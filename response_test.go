@@ -0,0 +1,222 @@
+package regrouter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestJSONErrorsServiceUnavailable(t *testing.T) {
+	tests := []struct {
+		name        string
+		use         func(rr *RegRouter)
+		contentType string
+		field       string
+	}{
+		{"UseJSONErrors", (*RegRouter).UseJSONErrors, "application/json", "code"},
+		{"UseProblemJSONErrors", (*RegRouter).UseProblemJSONErrors, "application/problem+json", "status"},
+	}
+	for _, test := range tests {
+		rr := New()
+		rr.Get("/", okHandler, false)
+		test.use(rr)
+		rr.Drain()
+
+		w := serve(rr, http.MethodGet, "/")
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: %v in %q", test.name, err, w.Body)
+		}
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Content-Type") != test.contentType || body[test.field] != float64(http.StatusServiceUnavailable) {
+			t.Errorf("%s: got %d %v %v", test.name, w.Code, w.Header(), body)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	rr := New()
+	w := httptest.NewRecorder()
+	if err := rr.JSON(w, http.StatusCreated, map[string]int{"a": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusCreated || w.Body.String() != "{\"a\":1}\n" || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("JSON: got %d %q %v", w.Code, w.Body, w.Header())
+	}
+
+	// Nothing is written for a value that can't be encoded
+	w = httptest.NewRecorder()
+	if err := rr.JSON(w, http.StatusOK, make(chan int)); err == nil {
+		t.Error("JSON of a channel: got nil error")
+	}
+	if w.Body.Len() != 0 || len(w.Header()) != 0 || w.Flushed {
+		t.Errorf("JSON of a channel: got %q %v", w.Body, w.Header())
+	}
+}
+
+func TestText(t *testing.T) {
+	w := httptest.NewRecorder()
+	if err := New().Text(w, http.StatusTeapot, "teapot"); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusTeapot || w.Body.String() != "teapot" || w.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("Text: got %d %q %v", w.Code, w.Body, w.Header())
+	}
+}
+
+func TestUseJSONErrors(t *testing.T) {
+	rr := New()
+	rr.UseJSONErrors()
+	rr.Get("/a", okHandler, false)
+	rr.Get("/panic", func(w http.ResponseWriter, r *http.Request) { panic("boom") }, false)
+	rr.Get(`/error/(?P<code>\d+)`, func(w http.ResponseWriter, r *http.Request) {
+		code, _ := rr.Params(r).GetInt("code")
+		rr.Error(code, map[string]interface{}{}, w, r)
+	}, false)
+
+	tests := map[string]int{"/missing": http.StatusNotFound, "/panic": http.StatusInternalServerError}
+	for _, code := range []int{http.StatusNotAcceptable, http.StatusRequestEntityTooLarge, http.StatusRequestHeaderFieldsTooLarge, http.StatusServiceUnavailable, http.StatusGatewayTimeout} {
+		tests[fmt.Sprintf("/error/%d", code)] = code
+	}
+	for path, code := range tests {
+		w := serve(rr, http.MethodGet, path)
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Errorf("GET %s: %v in %q", path, err, w.Body)
+			continue
+		}
+		if w.Code != code || body["code"] != float64(code) || body["error"] != http.StatusText(code) || w.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("GET %s: got %d %v %v, want %d", path, w.Code, body, w.Header(), code)
+		}
+	}
+
+	w := serve(rr, http.MethodPost, "/a")
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET" || w.Body.String() != `{"allowed":["GET"],"code":405,"error":"Method Not Allowed"}`+"\n" {
+		t.Errorf("POST /a: got %d %v %q", w.Code, w.Header(), w.Body)
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	rr := New()
+	rr.Redirect("/old", "/new", http.StatusMovedPermanently)
+	rr.Redirect(`/u/(?P<id>\d+)/(\w+)`, "/users/${id}/$2?v=1", http.StatusFound)
+
+	tests := []struct {
+		path     string
+		code     int
+		location string
+	}{
+		{"/old", http.StatusMovedPermanently, "/new"},
+		{"/old?a=b", http.StatusMovedPermanently, "/new?a=b"},
+		{"/u/5/x", http.StatusFound, "/users/5/x?v=1"},
+		{"/u/5/x?a=b", http.StatusFound, "/users/5/x?v=1&a=b"},
+	}
+	for _, test := range tests {
+		w := serve(rr, http.MethodGet, test.path)
+		if w.Code != test.code || w.Header().Get("Location") != test.location {
+			t.Errorf("GET %s: got %d %q, want %d %q", test.path, w.Code, w.Header().Get("Location"), test.code, test.location)
+		}
+	}
+}
+
+func TestRedirectEscapesParams(t *testing.T) {
+	rr := New()
+	rr.Redirect("/go/(?P<p>.*)", "/$p", http.StatusFound)
+	rr.Redirect("/find/(?P<q>.*)", "/search?q=$q", http.StatusFound)
+
+	tests := []struct {
+		path     string
+		location string
+	}{
+		{"/go/a/b", "/a/b"},
+		{"/go//evil.com", "/%2Fevil.com"},
+		{"/go/a//b", "/a/%2Fb"},
+		{"/go/a%3Fb", "/a%3Fb"},
+		{"/go/a%23b", "/a%23b"},
+		{"/find/a&b=c", "/search?q=a%26b%3Dc"},
+	}
+	for _, test := range tests {
+		w := serve(rr, http.MethodGet, test.path)
+		if w.Code != http.StatusFound || w.Header().Get("Location") != test.location {
+			t.Errorf("GET %s: got %d %q, want 302 %q", test.path, w.Code, w.Header().Get("Location"), test.location)
+		}
+	}
+}
+
+func TestNegotiatedErrors(t *testing.T) {
+	rr := New()
+	rr.Get("/a", okHandler, false)
+	rr.Get("/panic", func(w http.ResponseWriter, r *http.Request) { panic("bad") }, false)
+	serveAccept := func(method string, path string, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+
+		w := httptest.NewRecorder()
+		rr.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, accept := range []string{"application/json", "text/html;q=0.5, application/json", "application/problem+json"} {
+		w := serveAccept(http.MethodPost, "/a", accept)
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusMethodNotAllowed || w.Header().Get("Content-Type") != "application/json" || body["code"] != float64(http.StatusMethodNotAllowed) {
+			t.Errorf("POST /a accepting %q: got %d %v %q", accept, w.Code, w.Header(), w.Body)
+		}
+	}
+
+	for _, accept := range []string{"", "text/html,application/json", "*/*", "text/html, application/json;q=0.9", "garbage;;"} {
+		w := serveAccept(http.MethodGet, "/missing", accept)
+		if w.Code != http.StatusNotFound || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") || w.Body.String() != "404 - Not Found\n\n" {
+			t.Errorf("GET /missing accepting %q: got %d %v %q", accept, w.Code, w.Header(), w.Body)
+		}
+	}
+
+	tests := []struct {
+		method, path, accept string
+		want                 string
+	}{
+		{http.MethodGet, "/panic", "application/json", `{"code":500,"error":"Internal Server Error","exception":"bad"}` + "\n"},
+		{http.MethodGet, "/panic", "", "500 - Internal Server Error (Exception: bad)\n\n"},
+		{http.MethodPost, "/a", "", "405 - Method Not Allowed (Valid: GET)\n\n"},
+	}
+	for _, test := range tests {
+		if w := serveAccept(test.method, test.path, test.accept); w.Body.String() != test.want {
+			t.Errorf("%s %s accepting %q: got %q, want %q", test.method, test.path, test.accept, w.Body, test.want)
+		}
+	}
+}
+
+func TestUseProblemJSONErrors(t *testing.T) {
+	rr := New()
+	rr.UseProblemJSONErrors()
+	rr.Get("/a", okHandler, false)
+	rr.Get("/panic", func(w http.ResponseWriter, r *http.Request) { panic("boom") }, false)
+
+	tests := []struct {
+		method, path string
+		code         int
+		detail       string
+	}{
+		{http.MethodGet, "/missing", http.StatusNotFound, "No route matches GET /missing"},
+		{http.MethodPost, "/a", http.StatusMethodNotAllowed, "Valid: GET"},
+		{http.MethodGet, "/panic", http.StatusInternalServerError, "Exception: boom"},
+	}
+	for _, test := range tests {
+		w := serve(rr, test.method, test.path)
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Errorf("%s %s: %v in %q", test.method, test.path, err, w.Body)
+			continue
+		}
+		if w.Code != test.code || w.Header().Get("Content-Type") != "application/problem+json" {
+			t.Errorf("%s %s: got %d %v, want %d", test.method, test.path, w.Code, w.Header(), test.code)
+		}
+		if body["type"] != "about:blank" || body["title"] != http.StatusText(test.code) || body["status"] != float64(test.code) || body["detail"] != test.detail {
+			t.Errorf("%s %s: got %v, want detail %q", test.method, test.path, body, test.detail)
+		}
+	}
+}
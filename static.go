@@ -0,0 +1,138 @@
+package regrouter
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// StaticOptions configures how static files are served
+type StaticOptions struct {
+	DisableListing bool   // Respond 404 to directories without an index file.
+	IndexFile      string // Directory index file, index.html by default.
+}
+
+// Static servers static files. The pattern captures the file path in a group
+// named filepath, or else its first named group. A pattern without any group
+// gets a /*filepath catch-all param appended, while one with only unnamed
+// groups is an error.
+func (rr *RegRouter) Static(path string, pattern string, options ...StaticOptions) {
+	if err := rr.static(http.Dir(path), pattern, options); err != nil {
+		panic(err)
+	}
+}
+
+// StaticE serves static files as Static, returning why they can't be served,
+// including a path that is missing or not a directory
+func (rr *RegRouter) StaticE(path string, pattern string, options ...StaticOptions) error {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("%q: invalid static directory: %w", path, err)
+	} else if !stat.IsDir() {
+		return fmt.Errorf("%q: invalid static directory: not a directory", path)
+	}
+
+	return rr.static(http.Dir(path), pattern, options)
+}
+
+// StaticFS serves static files from a file system such as an embed.FS, the
+// pattern capturing the file path as with Static
+func (rr *RegRouter) StaticFS(fsys fs.FS, pattern string, options ...StaticOptions) {
+	if err := rr.static(http.FS(fsys), pattern, options); err != nil {
+		panic(err)
+	}
+}
+
+// File serves a single file for the pattern
+func (rr *RegRouter) File(pattern string, filepath string) {
+	rr.Add(http.MethodGet, pattern, func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath)
+	}, false)
+}
+
+// static serves the files named by the pattern's file path capture
+func (rr *RegRouter) static(fsys http.FileSystem, pattern string, options []StaticOptions) error {
+	name, err := fileCapture(pattern)
+	if err != nil {
+		return err
+	} else if name == "" {
+		pattern, name = strings.TrimSuffix(pattern, "/")+"/*filepath", "filepath"
+	}
+
+	for _, o := range options {
+		fsys = staticFS{fsys, o}
+	}
+
+	server := http.FileServer(fsys)
+	route := Route{method: http.MethodGet, handler: func(w http.ResponseWriter, r *http.Request) {
+		file := rr.Params(r).Get(name)
+		r.URL.Path = fmt.Sprintf("/%s", strings.TrimPrefix(file, "/"))
+		server.ServeHTTP(w, r)
+	}}
+
+	return rr.add(route, pattern)
+}
+
+// fileCapture returns the name of the group capturing the file path, or empty
+// string if there is no group at all
+func fileCapture(pattern string) (string, error) {
+	regex, err := regexp.Compile(expandCatchAll(pattern))
+	if err != nil {
+		return "", fmt.Errorf("%q: invalid pattern: %w", pattern, err)
+	}
+
+	var first string
+	for _, name := range regex.SubexpNames() {
+		if name == "filepath" {
+			return name, nil
+		} else if name != "" && first == "" {
+			first = name
+		}
+	}
+
+	if first == "" && regex.NumSubexp() > 0 {
+		return "", fmt.Errorf("%q: no named group capturing the file path", pattern)
+	}
+
+	return first, nil
+}
+
+// staticFS applies StaticOptions to a file system
+type staticFS struct {
+	http.FileSystem
+	options StaticOptions
+}
+
+// Open opens a file, hiding directories without an index file if listing is
+// disabled
+func (sfs staticFS) Open(name string) (http.File, error) {
+	// http.FileServer looks for index.html, so open the configured index instead
+	if sfs.options.IndexFile != "" && strings.HasSuffix(name, "/index.html") {
+		name = strings.TrimSuffix(name, "index.html") + sfs.options.IndexFile
+	}
+
+	file, err := sfs.FileSystem.Open(name)
+	if err != nil || !sfs.options.DisableListing {
+		return file, err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	} else if !stat.IsDir() {
+		return file, nil
+	}
+
+	index, err := sfs.Open(strings.TrimSuffix(name, "/") + "/index.html")
+	if err != nil {
+		file.Close()
+		return nil, os.ErrNotExist
+	}
+
+	index.Close()
+	return file, nil
+}
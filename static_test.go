@@ -0,0 +1,155 @@
+package regrouter
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestStaticCatchAll(t *testing.T) {
+	fsys := fstest.MapFS{"css/site.css": {Data: []byte("body{}")}}
+	rr := New()
+	rr.StaticFS(fsys, "/assets")
+	rr.StaticFS(fsys, "/files/*filepath")
+
+	for _, path := range []string{"/assets/css/site.css", "/files/css/site.css"} {
+		if w := serve(rr, http.MethodGet, path); w.Code != http.StatusOK || w.Body.String() != "body{}" {
+			t.Errorf("GET %s: got %d %q", path, w.Code, w.Body)
+		}
+	}
+}
+
+func TestStaticE(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hi"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := New()
+	if err := rr.StaticE(dir, "/s"); err != nil {
+		t.Fatalf("StaticE of a directory: got %v", err)
+	}
+	if w := serve(rr, http.MethodGet, "/s/a.txt"); w.Code != http.StatusOK || w.Body.String() != "hi" {
+		t.Errorf("GET /s/a.txt: got %d %q", w.Code, w.Body)
+	}
+
+	if err := rr.StaticE(filepath.Join(dir, "missing"), "/m"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("StaticE of a missing directory: got %v, want ErrNotExist", err)
+	}
+	if err := rr.StaticE(filepath.Join(dir, "a.txt"), "/f"); err == nil {
+		t.Error("StaticE of a file: got nil error")
+	}
+	if got := len(rr.Routes()); got != 1 {
+		t.Errorf("routes after failed StaticE calls: got %d, want 1", got)
+	}
+}
+
+func TestStaticUnnamedGroup(t *testing.T) {
+	rr := New()
+	if err := rr.StaticE(t.TempDir(), "/assets/(.*)"); err == nil {
+		t.Fatal("StaticE with only an unnamed group: got nil error")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Static with only an unnamed group didn't panic")
+		}
+	}()
+	rr.StaticFS(fstest.MapFS{}, "/assets/(.*)")
+}
+
+func TestStaticFS(t *testing.T) {
+	rr := New()
+	rr.StaticFS(fstest.MapFS{"css/a.css": {Data: []byte("body{}")}}, "/static/(?P<filepath>.*)")
+
+	w := serve(rr, http.MethodGet, "/static/css/a.css")
+	if w.Code != http.StatusOK || w.Body.String() != "body{}" || w.Header().Get("Content-Type") != "text/css; charset=utf-8" {
+		t.Errorf("GET /static/css/a.css: got %d %q %v", w.Code, w.Body, w.Header())
+	}
+	if w := serve(rr, http.MethodGet, "/static/missing.css"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /static/missing.css: got %d, want 404", w.Code)
+	}
+}
+
+func TestStaticCapture(t *testing.T) {
+	fsys := fstest.MapFS{"a.txt": {Data: []byte("A")}, "d/e/f.txt": {Data: []byte("F")}}
+	rr := New()
+	rr.StaticFS(fsys, "/s/(?P<bucket>[a-z]+)/(?P<filepath>.*)")
+	rr.StaticFS(fsys, "/t/(?P<file>.*)")
+	rr.StaticFS(fsys, "/u/")
+
+	// The filepath group wins over an earlier named group, else the first
+	// named group is used, and a pattern without any gets a catch-all
+	tests := map[string]string{"/s/q/a.txt": "A", "/t/a.txt": "A", "/t/d/e/f.txt": "F", "/u/d/e/f.txt": "F"}
+	for path, want := range tests {
+		if w := serve(rr, http.MethodGet, path); w.Code != http.StatusOK || w.Body.String() != want {
+			t.Errorf("GET %s: got %d %q, want %q", path, w.Code, w.Body, want)
+		}
+	}
+}
+
+func TestStaticListing(t *testing.T) {
+	fsys := fstest.MapFS{"a/x.txt": {Data: []byte("X")}, "b/main.htm": {Data: []byte("MAIN")}, "c/index.html": {Data: []byte("INDEX")}}
+	rr := New()
+	rr.StaticFS(fsys, "/s/(?P<filepath>.*)", StaticOptions{DisableListing: true, IndexFile: "main.htm"})
+	rr.StaticFS(fsys, "/d/(?P<filepath>.*)", StaticOptions{DisableListing: true})
+	rr.StaticFS(fsys, "/l/(?P<filepath>.*)")
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/s/a/", http.StatusNotFound, ""},
+		{"/s/a/x.txt", http.StatusOK, "X"},
+		{"/s/b/", http.StatusOK, "MAIN"},
+		{"/d/c/", http.StatusOK, "INDEX"},
+		{"/d/", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		w := serve(rr, http.MethodGet, test.path)
+		if w.Code != test.code || test.body != "" && w.Body.String() != test.body {
+			t.Errorf("GET %s: got %d %q, want %d %q", test.path, w.Code, w.Body, test.code, test.body)
+		}
+	}
+
+	// Listing is enabled by default
+	if w := serve(rr, http.MethodGet, "/l/a/"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "x.txt") {
+		t.Errorf("GET /l/a/: got %d %q, want a listing", w.Code, w.Body)
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	icon := filepath.Join(dir, "favicon.ico")
+	if err := os.WriteFile(icon, []byte("\x00\x00\x01\x00icon"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	style := filepath.Join(dir, "a.css")
+	if err := os.WriteFile(style, []byte("body{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := New()
+	rr.File("/favicon.ico", icon)
+	rr.File("/style", style)
+
+	// The content type comes from the file, not the route
+	tests := []struct {
+		path, body, contentType string
+	}{
+		{"/favicon.ico", "\x00\x00\x01\x00icon", "image/vnd.microsoft.icon"},
+		{"/style", "body{}", "text/css; charset=utf-8"},
+	}
+	for _, test := range tests {
+		w := serve(rr, http.MethodGet, test.path)
+		if w.Code != http.StatusOK || w.Body.String() != test.body || w.Header().Get("Content-Type") != test.contentType {
+			t.Errorf("GET %s: got %d %q %v, want %q", test.path, w.Code, w.Body, w.Header(), test.contentType)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package regrouter
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// timeoutWriter buffers the response of a handler that may time out
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	body     bytes.Buffer
+	status   int
+	timedOut bool
+}
+
+// AddTimeout adds a route whose request context is cancelled after the
+// timeout, responding with a 504 error if the handler hasn't returned by then
+func (rr *RegRouter) AddTimeout(method string, pattern string, handler http.HandlerFunc, cors bool, timeout time.Duration) {
+	if err := rr.add(Route{method: method, handler: handler, middleware: []Middleware{rr.timeout(timeout)}, CORS: cors}, pattern); err != nil {
+		panic(err)
+	}
+}
+
+// timeout returns middleware running the handler with a deadline, buffering
+// its response until it returns
+func (rr *RegRouter) timeout(timeout time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			tw := &timeoutWriter{header: http.Header{}}
+			done := make(chan struct{})
+			panicked := make(chan interface{}, 1)
+			go func() {
+				defer func() {
+					if err := recover(); err != nil {
+						panicked <- err
+						return
+					}
+
+					close(done)
+				}()
+
+				next.ServeHTTP(tw, r.WithContext(ctx))
+			}()
+
+			select {
+			case err := <-panicked:
+				panic(err)
+			case <-done:
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				for key, values := range tw.header {
+					w.Header()[key] = values
+				}
+
+				if tw.status == 0 {
+					tw.status = http.StatusOK
+				}
+
+				w.WriteHeader(tw.status)
+				w.Write(tw.body.Bytes())
+			case <-ctx.Done():
+				tw.mu.Lock()
+				tw.timedOut = true
+				tw.mu.Unlock()
+
+				// Leave requests cancelled by the client unanswered
+				if ctx.Err() == context.DeadlineExceeded {
+					rr.Error(http.StatusGatewayTimeout, map[string]interface{}{}, w, r)
+				}
+			}
+		})
+	}
+}
+
+// Header returns the buffered header
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+// WriteHeader buffers the status
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if !tw.timedOut && tw.status == 0 {
+		tw.status = code
+	}
+}
+
+// Write buffers the body. Writes racing the timeout are discarded, and those
+// after the timeout response was sent fail with http.ErrHandlerTimeout.
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	} else if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+
+	return tw.body.Write(b)
+}
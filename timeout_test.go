@@ -0,0 +1,67 @@
+package regrouter
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeoutInTime(t *testing.T) {
+	rr := New()
+	rr.AddTimeout(http.MethodGet, `/fast/(?P<id>\d+)`, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Id", rr.Params(r).Get("id"))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("ok"))
+	}, false, time.Second)
+
+	w := serve(rr, http.MethodGet, "/fast/7")
+	if w.Code != http.StatusCreated || w.Body.String() != "ok" || w.Header().Get("X-Id") != "7" {
+		t.Errorf("GET /fast/7: got %d %q %v, want 201 \"ok\"", w.Code, w.Body, w.Header())
+	}
+}
+
+func TestTimeoutExceeded(t *testing.T) {
+	rr := New()
+	cancelled, served := make(chan error, 1), make(chan struct{})
+	late := make(chan error, 1)
+	rr.AddTimeout(http.MethodGet, "/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		cancelled <- r.Context().Err()
+
+		// Write only once the timeout response has been sent
+		<-served
+		_, err := w.Write([]byte("late"))
+		late <- err
+	}, false, 20*time.Millisecond)
+
+	w := serve(rr, http.MethodGet, "/slow")
+	close(served)
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("GET /slow: got %d, want 504", w.Code)
+	}
+
+	// The cancellation reaches the handler, whose writes after the response
+	// fail
+	if err := <-cancelled; err != context.DeadlineExceeded {
+		t.Errorf("handler context: got %v, want DeadlineExceeded", err)
+	}
+	if err := <-late; err != http.ErrHandlerTimeout {
+		t.Errorf("late write: got %v, want ErrHandlerTimeout", err)
+	}
+	if strings.Contains(w.Body.String(), "late") {
+		t.Errorf("GET /slow: got the late write in %q", w.Body)
+	}
+}
+
+func TestTimeoutPanic(t *testing.T) {
+	rr := New()
+	rr.AddTimeout(http.MethodGet, "/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}, false, time.Second)
+
+	if w := serve(rr, http.MethodGet, "/panic"); w.Code != http.StatusInternalServerError {
+		t.Errorf("GET /panic: got %d, want 500", w.Code)
+	}
+}
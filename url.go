@@ -0,0 +1,170 @@
+package regrouter
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+)
+
+// URL builds the path of a named route, substituting params for its capture
+// groups by name, or by index for unnamed groups. Outside of captures the
+// pattern may only contain literals and optional groups.
+func (rr *RegRouter) URL(name string, params map[string]string) (string, error) {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	for _, route := range rr.routes {
+		if route.name != name {
+			continue
+		}
+
+		expr, err := route.expr()
+		if err != nil {
+			return "", fmt.Errorf("%q: invalid pattern: %w", route.pattern, err)
+		}
+
+		re, err := syntax.Parse(expr, syntax.Perl)
+		if err != nil {
+			return "", fmt.Errorf("%q: invalid pattern: %w", route.pattern, err)
+		}
+
+		// Match the path the route would, escaped or not
+		var path urlPath
+		if err := reverse(re, params, &path); err != nil {
+			return "", fmt.Errorf("%q: %w", name, err)
+		}
+
+		matched := path.decoded.String()
+		if rr.rawPath {
+			matched = path.escaped.String()
+		}
+
+		if route.match(matched) == nil {
+			return "", fmt.Errorf("%q: %q doesn't match the route pattern", name, matched)
+		}
+
+		return path.escaped.String(), nil
+	}
+
+	return "", fmt.Errorf("%q: no such route", name)
+}
+
+// urlPath is a path being built, with its params escaped or not
+type urlPath struct {
+	decoded strings.Builder
+	escaped strings.Builder
+}
+
+// write writes literal path text
+func (path *urlPath) write(s string) {
+	path.decoded.WriteString(s)
+	path.escaped.WriteString(s)
+}
+
+// writeParam writes a param value, escaping it. Slashes are kept if the group
+// capturing it matches them, as a catch-all param does.
+func (path *urlPath) writeParam(re *syntax.Regexp, value string) {
+	path.decoded.WriteString(value)
+	if !strings.Contains(value, "/") {
+		path.escaped.WriteString(url.PathEscape(value))
+		return
+	}
+
+	group, err := regexp.Compile("^(?:" + re.String() + ")$")
+	if err != nil || !group.MatchString(value) {
+		path.escaped.WriteString(url.PathEscape(value))
+		return
+	}
+
+	segments := strings.Split(value, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	path.escaped.WriteString(strings.Join(segments, "/"))
+}
+
+// reverse writes the path matched by a parsed pattern given its params
+func reverse(re *syntax.Regexp, params map[string]string, path *urlPath) error {
+	switch re.Op {
+	case syntax.OpLiteral:
+		path.write(string(re.Rune))
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if err := reverse(sub, params, path); err != nil {
+				return err
+			}
+		}
+	case syntax.OpCapture:
+		key := re.Name
+		if key == "" {
+			key = strconv.Itoa(re.Cap)
+		}
+
+		if value, ok := params[key]; ok {
+			path.writeParam(re.Sub[0], value)
+			return nil
+		}
+
+		// Unnamed groups without a param may be built from their contents
+		var sub urlPath
+		if re.Name != "" || reverse(re.Sub[0], params, &sub) != nil {
+			return fmt.Errorf("%q: %w", key, ErrNoSuchParam)
+		}
+
+		path.decoded.WriteString(sub.decoded.String())
+		path.escaped.WriteString(sub.escaped.String())
+	case syntax.OpQuest:
+		// Only include optional groups with a param for their captures
+		if captured(re.Sub[0], params) {
+			return reverse(re.Sub[0], params, path)
+		}
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText:
+	default:
+		return fmt.Errorf("%q: can't build a URL from the pattern", re.String())
+	}
+
+	return nil
+}
+
+// captured reports whether a parsed pattern has a capture group with a param
+func captured(re *syntax.Regexp, params map[string]string) bool {
+	if re.Op == syntax.OpCapture {
+		key := re.Name
+		if key == "" {
+			key = strconv.Itoa(re.Cap)
+		}
+
+		if _, ok := params[key]; ok {
+			return true
+		}
+	}
+
+	for _, sub := range re.Sub {
+		if captured(sub, params) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// captureGroups adds the contents of the capture groups in a parsed pattern to
+// groups by name, or by index for unnamed groups
+func captureGroups(re *syntax.Regexp, groups map[string]*syntax.Regexp) {
+	if re.Op == syntax.OpCapture {
+		key := re.Name
+		if key == "" {
+			key = strconv.Itoa(re.Cap)
+		}
+
+		groups[key] = re.Sub[0]
+	}
+
+	for _, sub := range re.Sub {
+		captureGroups(sub, groups)
+	}
+}
@@ -0,0 +1,80 @@
+package regrouter
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestURL(t *testing.T) {
+	rr := New()
+	rr.AddNamed("home", http.MethodGet, "/", okHandler, false)
+	rr.AddNamed("post", http.MethodGet, `/users/(?P<user>\d+)/posts/(?P<post>[a-z-]+)(/(?P<page>\d+))?`, okHandler, false)
+	rr.AddNamed("index", http.MethodGet, `/items/(\d+)`, okHandler, false)
+
+	tests := []struct {
+		name   string
+		params map[string]string
+		want   string
+	}{
+		{"home", nil, "/"},
+		{"post", map[string]string{"user": "5", "post": "hello-world"}, "/users/5/posts/hello-world"},
+		{"post", map[string]string{"user": "5", "post": "hi", "page": "2"}, "/users/5/posts/hi/2"},
+		{"index", map[string]string{"1": "9"}, "/items/9"},
+	}
+	for _, test := range tests {
+		if got, err := rr.URL(test.name, test.params); err != nil || got != test.want {
+			t.Errorf("URL(%q, %v): got %q, %v, want %q", test.name, test.params, got, err, test.want)
+		}
+	}
+}
+
+func TestURLErrors(t *testing.T) {
+	rr := New()
+	rr.AddNamed("post", http.MethodGet, `/users/(?P<user>\d+)/posts/(?P<post>[a-z-]+)`, okHandler, false)
+	rr.AddNamed("wildcard", http.MethodGet, "/any/.*", okHandler, false)
+
+	if _, err := rr.URL("post", map[string]string{"user": "5"}); !errors.Is(err, ErrNoSuchParam) {
+		t.Errorf("missing param: got %v, want ErrNoSuchParam", err)
+	}
+	if _, err := rr.URL("post", map[string]string{"user": "x", "post": "a"}); err == nil {
+		t.Error("param not matching its group: got nil error")
+	}
+	if _, err := rr.URL("wildcard", nil); err == nil {
+		t.Error("pattern without a literal path: got nil error")
+	}
+	if _, err := rr.URL("missing", nil); err == nil {
+		t.Error("unknown route: got nil error")
+	}
+}
+
+func TestURLEscapesParams(t *testing.T) {
+	rr := New()
+	rr.AddNamed("user", http.MethodGet, "/u/(?P<id>[^/]+)", okHandler, false)
+	rr.AddNamed("files", http.MethodGet, "/files/*path", okHandler, false)
+
+	got, err := rr.URL("user", map[string]string{"id": "a b?c"})
+	if err != nil || got != "/u/a%20b%3Fc" {
+		t.Errorf(`URL("user"): got %q, %v, want "/u/a%%20b%%3Fc"`, got, err)
+	}
+
+	// The built URL routes back to the same param
+	var id string
+	rr.Get("/check/(?P<id>[^/]+)", func(w http.ResponseWriter, r *http.Request) {
+		id = rr.Params(r).Get("id")
+	}, false)
+	serve(rr, http.MethodGet, "/check"+got[len("/u"):])
+	if id != "a b?c" {
+		t.Errorf("routed param: got %q, want %q", id, "a b?c")
+	}
+
+	// Catch-all params keep their slashes
+	if got, err := rr.URL("files", map[string]string{"path": "a dir/x.txt"}); err != nil || got != "/files/a%20dir/x.txt" {
+		t.Errorf(`URL("files"): got %q, %v, want "/files/a%%20dir/x.txt"`, got, err)
+	}
+
+	// A slash a single segment group can't match is an error
+	if _, err := rr.URL("user", map[string]string{"id": "a/b"}); err == nil {
+		t.Error(`URL("user") with a slash: got nil error`)
+	}
+}
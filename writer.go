@@ -0,0 +1,135 @@
+package regrouter
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+)
+
+// responseWriter records the status written through an http.ResponseWriter
+type responseWriter struct {
+	http.ResponseWriter
+	status   int
+	hijacked bool
+}
+
+// Combinations of the optional interfaces the underlying writer implements
+type (
+	flushWriter       struct{ *responseWriter }
+	hijackWriter      struct{ *responseWriter }
+	flushHijackWriter struct{ *responseWriter }
+)
+
+// wrapWriter wraps a writer, keeping the http.Flusher and http.Hijacker
+// interfaces it implements. Its io.ReaderFrom is used when there is one.
+func wrapWriter(w http.ResponseWriter) (*responseWriter, http.ResponseWriter) {
+	rw := &responseWriter{ResponseWriter: w}
+	_, flusher := w.(http.Flusher)
+	_, hijacker := w.(http.Hijacker)
+
+	switch {
+	case flusher && hijacker:
+		return rw, flushHijackWriter{rw}
+	case flusher:
+		return rw, flushWriter{rw}
+	case hijacker:
+		return rw, hijackWriter{rw}
+	}
+
+	return rw, rw
+}
+
+// WriteHeader records and writes the status
+func (rw *responseWriter) WriteHeader(code int) {
+	if rw.status == 0 {
+		rw.status = code
+	}
+
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+// Write writes the body, implying a 200 status if none was written
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+
+	return rw.ResponseWriter.Write(b)
+}
+
+// ReadFrom writes the body from the reader with the underlying writer's
+// ReadFrom, so a server can send files with sendfile, implying a 200 status
+// once anything was written
+func (rw *responseWriter) ReadFrom(src io.Reader) (int64, error) {
+	rf, ok := rw.ResponseWriter.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(struct{ io.Writer }{rw}, src)
+	}
+
+	n, err := rf.ReadFrom(src)
+	if n > 0 && rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+
+	return n, err
+}
+
+// Unwrap returns the underlying writer
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// flush flushes the underlying writer, implying a 200 status
+func (rw *responseWriter) flush() {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+
+	rw.ResponseWriter.(http.Flusher).Flush()
+}
+
+// hijack hijacks the underlying connection
+func (rw *responseWriter) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buf, err := rw.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil {
+		rw.hijacked = true
+	}
+
+	return conn, buf, err
+}
+
+// Flush flushes the underlying writer
+func (fw flushWriter) Flush() {
+	fw.flush()
+}
+
+// Hijack hijacks the underlying connection
+func (hw hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return hw.hijack()
+}
+
+// Flush flushes the underlying writer
+func (fhw flushHijackWriter) Flush() {
+	fhw.flush()
+}
+
+// Hijack hijacks the underlying connection
+func (fhw flushHijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return fhw.hijack()
+}
+
+// statusCode returns the response status, 200 if none was written
+func (rw *responseWriter) statusCode() int {
+	if rw.status == 0 {
+		return http.StatusOK
+	}
+
+	return rw.status
+}
+
+// written reports whether the response has been started or the connection
+// hijacked
+func (rw *responseWriter) written() bool {
+	return rw.status != 0 || rw.hijacked
+}
@@ -0,0 +1,128 @@
+package regrouter
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// plainWriter is a ResponseWriter without any optional interface
+type plainWriter struct {
+	header http.Header
+	code   int
+}
+
+func (w *plainWriter) Header() http.Header         { return w.header }
+func (w *plainWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *plainWriter) WriteHeader(code int)        { w.code = code }
+
+func TestWriterInterfaces(t *testing.T) {
+	rr := New()
+	var flusher, hijacker bool
+	rr.Get("/a", func(w http.ResponseWriter, r *http.Request) {
+		_, flusher = w.(http.Flusher)
+		_, hijacker = w.(http.Hijacker)
+	}, false)
+
+	rr.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/a", nil))
+	if !flusher || hijacker {
+		t.Errorf("recorder: got Flusher %t, Hijacker %t, want true, false", flusher, hijacker)
+	}
+
+	rr.ServeHTTP(&plainWriter{header: http.Header{}}, httptest.NewRequest(http.MethodGet, "/a", nil))
+	if flusher || hijacker {
+		t.Errorf("plain writer: got Flusher %t, Hijacker %t, want false, false", flusher, hijacker)
+	}
+}
+
+func TestWriterStatus(t *testing.T) {
+	rr := New()
+	var status int
+	rr.Handlers.Log = func(r *http.Request, code int, d time.Duration) { status = code }
+	rr.Get("/created", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.WriteHeader(http.StatusTeapot)
+	}, false)
+	rr.Get("/written", okHandler, false)
+
+	if w := serve(rr, http.MethodGet, "/created"); w.Code != http.StatusCreated || status != http.StatusCreated {
+		t.Errorf("GET /created: got %d, recorded %d, want 201", w.Code, status)
+	}
+	if serve(rr, http.MethodGet, "/written"); status != http.StatusOK {
+		t.Errorf("GET /written: recorded %d, want 200", status)
+	}
+}
+
+// readerFromWriter is a ResponseWriter implementing io.ReaderFrom, counting
+// the calls
+type readerFromWriter struct {
+	plainWriter
+	calls int
+}
+
+func (w *readerFromWriter) ReadFrom(src io.Reader) (int64, error) {
+	w.calls++
+	return io.Copy(io.Discard, src)
+}
+
+func TestWriterReadFrom(t *testing.T) {
+	rr := New()
+	var status int
+	rr.Handlers.Log = func(r *http.Request, code int, d time.Duration) { status = code }
+	rr.Get("/a", func(w http.ResponseWriter, r *http.Request) {
+		// Hide WriteTo from io.Copy, as ServeContent does
+		io.Copy(w, io.LimitReader(strings.NewReader("body"), 4))
+	}, false)
+	rr.Get("/empty", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, strings.NewReader(""))
+		w.WriteHeader(http.StatusNoContent)
+	}, false)
+
+	// The underlying ReadFrom sends the body
+	w := &readerFromWriter{plainWriter: plainWriter{header: http.Header{}}}
+	if rr.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/a", nil)); w.calls != 1 || status != http.StatusOK {
+		t.Errorf("GET /a: got %d ReadFrom calls, recorded %d, want 1 and 200", w.calls, status)
+	}
+	w = &readerFromWriter{plainWriter: plainWriter{header: http.Header{}}}
+	if rr.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/empty", nil)); status != http.StatusNoContent {
+		t.Errorf("GET /empty: recorded %d, want 204", status)
+	}
+
+	// Or it's copied to writers without one
+	if w := serve(rr, http.MethodGet, "/a"); w.Body.String() != "body" || status != http.StatusOK {
+		t.Errorf("GET /a from the recorder: got %q, recorded %d, want \"body\" and 200", w.Body, status)
+	}
+}
+
+func TestWriterHijack(t *testing.T) {
+	rr := New()
+	rr.Get("/hijack", func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		buf.WriteString("HTTP/1.1 299 Hijacked\r\nContent-Length: 0\r\n\r\n")
+		buf.Flush()
+		conn.Close()
+
+		// A hijacked connection counts as a started response
+		panic("after hijack")
+	}, false)
+
+	server := httptest.NewServer(rr)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/hijack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	if res.StatusCode != 299 {
+		t.Errorf("GET /hijack: got %d, want 299", res.StatusCode)
+	}
+}